@@ -1,18 +1,44 @@
 /*
 Package access has simple functions for checking whether a *nix user has the
 permissions to access a file (or folder).
+
+On Windows, the package builds but checks return ErrUnsupported.
+
+Like the kernel, checks distinguish a denied access from a missing file only when the
+user could tell them apart: if the user cannot search a directory on the path, a
+*PermissionError is returned, regardless of whether the requested file exists. Otherwise,
+if a file on the path does not exist, an error matching fs.ErrNotExist is returned.
+A *PermissionError matches ErrPermission and fs.ErrPermission, but never fs.ErrNotExist,
+so both cases can be told apart with errors.As and errors.Is.
+
+As with the kernel, a path with a trailing separator (for example /srv/data/) requires its
+final component to be a directory: if it is a symlink, it is followed, and if it is not a
+directory, a *NotDirError is returned. Repeated separators are treated as a single one.
+
+Relative paths, including single components like "foo", are relative to the current working
+directory of the calling process, as with os.Open, not to the home directory of the user nor to
+the root directory. An empty path is rejected with ErrEmptyPath, rather than checking the
+working directory as filepath.Abs("") would.
+
+The requested mode is a combination of Read, Write and Execute, which are the low "other"
+permission bits, whatever the class (owner, group or other) that applies to the user. Any
+other bit, for example os.FileMode(0400) for the owner read bit, is rejected with an error
+matching ErrInvalidMode rather than silently checked as a different permission.
 */
 package access
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Read permission (r)
@@ -21,40 +47,1508 @@ const Read = os.FileMode(4)
 // Write permission (w)
 const Write = os.FileMode(2)
 
-// Execute permission (x)
-const Execute = os.FileMode(1)
+// Execute permission (x)
+const Execute = os.FileMode(1)
+
+// Search permission (x), the execute permission of directories: searching a directory
+// is looking up (traversing) its entries, which is required to access any file below it.
+//
+// Search is an alias of Execute: requesting Execute on a directory checks its search
+// permission, and every ancestor directory of a file is checked for Search.
+const Search = Execute
+
+// ErrEmptyPath is returned when a path is empty.
+var ErrEmptyPath = errors.New("access: empty path")
+
+// ErrInvalidMode is returned when a requested mode is not a combination of Read, Write
+// and Execute.
+var ErrInvalidMode = errors.New("access: invalid mode")
+
+// ErrUnsupported is returned when checking permissions is not supported on the
+// current platform.
+var ErrUnsupported = errors.New("access: unsupported platform")
+
+// ErrPermission is matched by errors.Is for any *PermissionError, regardless of the file
+// and the permissions it is about.
+var ErrPermission = errors.New("access: permission denied")
+
+// ErrReadOnlyFS is returned when Write is requested on a file of a filesystem
+// mounted read-only, if read-only mounts are detected (see WithReadOnlyCheck).
+var ErrReadOnlyFS = errors.New("access: read-only file system")
+
+// ErrImmutable is returned when Write is requested on an immutable file, if
+// file attributes are honored (see WithAttrCheck).
+var ErrImmutable = errors.New("access: immutable file")
+
+// ErrAppendOnly is returned when Write is requested on an append-only file, if
+// file attributes are honored (see WithAttrCheck).
+//
+// The file can still be opened for appending.
+var ErrAppendOnly = errors.New("access: append-only file")
+
+// ErrMultiplyLinked is returned when Write is requested on a file that has more
+// than one hard link, if such writes are rejected (see WithRejectMultiplyLinkedWrites).
+var ErrMultiplyLinked = errors.New("access: multiply linked file")
+
+// ErrRaced is returned when the file being checked kept changing during the check, if the
+// Checker retries such checks.
+var ErrRaced = errors.New("access: file changed during check")
+
+// ErrCheckerDenied is returned when the calling process itself lacks the permissions
+// to read the metadata of a file needed for a check.
+var ErrCheckerDenied = errors.New("access: checking process denied")
+
+// ErrSpecialFS is returned when a path resolves to a file of a pseudo-filesystem,
+// if such files are rejected (see WithRejectSpecialFS).
+var ErrSpecialFS = errors.New("access: file on a special filesystem")
+
+// ErrTooManyLinks is returned when too many symlinks are encountered while
+// resolving a path, usually the sign of a symlink loop.
+var ErrTooManyLinks = errors.New("access: too many links")
+
+// ErrSymlink is returned when a symlink is encountered while resolving a path,
+// if the Checker disallows symlinks.
+var ErrSymlink = errors.New("access: symlink not allowed")
+
+// ErrCrossDevice is returned when a path crosses to another device, if the
+// Checker must stay on the device of the root directory.
+var ErrCrossDevice = errors.New("access: path crosses device")
+
+// ErrSymlinkEscape is returned when too many symlinks lead outside the directory
+// of the requested file, if the Checker limits them.
+var ErrSymlinkEscape = errors.New("access: too many symlink escapes")
+
+// ErrSymlinkTargetNotAllowed is returned when a symlink points outside the allowed
+// symlink targets, if the Checker restricts them.
+var ErrSymlinkTargetNotAllowed = errors.New("access: symlink target not allowed")
+
+// ErrNonCanonicalPath is returned when a path is not absolute and clean, where
+// a canonical path is required.
+var ErrNonCanonicalPath = errors.New("access: non-canonical path")
+
+// TooManyLinksError is returned when more symlinks than the maximum symlink
+// depth of the Checker are encountered while resolving a path.
+//
+// It wraps ErrTooManyLinks.
+type TooManyLinksError struct {
+	// absolute path whose resolution was aborted, as requested (before resolving any symlink)
+	Path string
+	// number of symlinks walked when the resolution was aborted
+	Links int
+	// maximum symlink depth that was exceeded
+	Limit int
+}
+
+func (p *TooManyLinksError) Error() string {
+	return fmt.Sprintf("%v: walked %d links resolving %s, limit %d", ErrTooManyLinks, p.Links, p.Path, p.Limit)
+}
+
+func (p *TooManyLinksError) Unwrap() error {
+	return ErrTooManyLinks
+}
+
+// SymlinkError is returned when a component of a path is a symlink, if the
+// Checker disallows symlinks (see WithDisallowSymlinks), or by CheckSafeDir
+// when the final component of a path is a symlink.
+//
+// It wraps ErrSymlink.
+type SymlinkError struct {
+	// path of the component that is a symlink
+	Path string
+}
+
+func (p *SymlinkError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrSymlink, p.Path)
+}
+
+func (p *SymlinkError) Unwrap() error {
+	return ErrSymlink
+}
+
+// CrossDeviceError is returned when a component of a path is on a different device
+// than the root directory, if the Checker must stay on it (see WithStayOnDevice).
+//
+// It wraps ErrCrossDevice.
+type CrossDeviceError struct {
+	// path of the component on a different device
+	Path string
+	// device of the component
+	Dev uint64
+	// device of the root directory
+	RootDev uint64
+}
+
+func (p *CrossDeviceError) Error() string {
+	return fmt.Sprintf("%v: %s is on device %d, not %d", ErrCrossDevice, p.Path, p.Dev, p.RootDev)
+}
+
+func (p *CrossDeviceError) Unwrap() error {
+	return ErrCrossDevice
+}
+
+// SymlinkEscapeError is returned when more symlinks than the maximum symlink escapes
+// of the Checker lead outside the directory of the requested file (see WithMaxSymlinkEscapes).
+//
+// It wraps ErrSymlinkEscape.
+type SymlinkEscapeError struct {
+	// path of the symlink that exceeded the maximum
+	Path string
+	// target of the symlink
+	Link string
+	// number of escapes when the resolution was aborted
+	Escapes int
+}
+
+func (p *SymlinkEscapeError) Error() string {
+	return fmt.Sprintf("%v: %s -> %s is escape %d", ErrSymlinkEscape, p.Path, p.Link, p.Escapes)
+}
+
+func (p *SymlinkEscapeError) Unwrap() error {
+	return ErrSymlinkEscape
+}
+
+// SymlinkTargetError is returned when a symlink followed while resolving a path points
+// outside the allowed symlink targets of the Checker (see WithAllowedSymlinkTargets).
+//
+// It wraps ErrSymlinkTargetNotAllowed.
+type SymlinkTargetError struct {
+	// path of the symlink
+	Path string
+	// target of the symlink, as read
+	Link string
+	// target of the symlink, absolute and clean
+	Target string
+}
+
+func (p *SymlinkTargetError) Error() string {
+	return fmt.Sprintf("%v: %s -> %s", ErrSymlinkTargetNotAllowed, p.Path, p.Target)
+}
+
+func (p *SymlinkTargetError) Unwrap() error {
+	return ErrSymlinkTargetNotAllowed
+}
+
+// NonCanonicalPathError is returned by UidCanonical when a path is not absolute and clean.
+//
+// It wraps ErrNonCanonicalPath.
+type NonCanonicalPathError struct {
+	// path as passed by the caller
+	Path string
+}
+
+func (p *NonCanonicalPathError) Error() string {
+	return fmt.Sprintf("%v: %q", ErrNonCanonicalPath, p.Path)
+}
+
+func (p *NonCanonicalPathError) Unwrap() error {
+	return ErrNonCanonicalPath
+}
+
+// NotDirError is returned when a component of a path that should be a directory
+// (because it is followed by other components) is not a directory.
+//
+// It wraps syscall.ENOTDIR.
+type NotDirError struct {
+	// path of the component that is not a directory
+	Path string
+}
+
+func (p *NotDirError) Error() string {
+	return "access: not a directory: " + p.Path
+}
+
+func (p *NotDirError) Unwrap() error {
+	return syscall.ENOTDIR
+}
+
+// CheckerDeniedError is returned when the calling process (not the checked user) was denied
+// reading a file needed for a check, for example when it runs unprivileged and cannot search
+// a directory that the checked user can. Nothing can be concluded about the checked user.
+//
+// The search permission of the checked user on a directory is always checked before the
+// files in the directory are read, so that if the checked user cannot search it either, the
+// PermissionError of the checked user is returned instead, and the error of the calling
+// process is not leaked.
+//
+// It wraps both ErrCheckerDenied and the underlying error, which wraps syscall.EACCES, but
+// it is not a PermissionError and does not match ErrPermission.
+type CheckerDeniedError struct {
+	// path of the file that the calling process could not read
+	Path string
+	// underlying error
+	Err error
+}
+
+func (p *CheckerDeniedError) Error() string {
+	return fmt.Sprintf("%v: cannot read %s: %v", ErrCheckerDenied, p.Path, p.Err)
+}
+
+func (p *CheckerDeniedError) Unwrap() []error {
+	return []error{ErrCheckerDenied, p.Err}
+}
+
+// SpecialFSError is returned when a path resolves to a file of a pseudo-filesystem,
+// if such files are rejected (see WithRejectSpecialFS).
+//
+// It wraps ErrSpecialFS.
+type SpecialFSError struct {
+	// resolved path of the file
+	Path string
+	// type of the filesystem of the file, see FSInfo
+	FSType string
+}
+
+func (p *SpecialFSError) Error() string {
+	return fmt.Sprintf("%v: %s is on %s", ErrSpecialFS, p.Path, p.FSType)
+}
+
+func (p *SpecialFSError) Unwrap() error {
+	return ErrSpecialFS
+}
+
+// PermissionError is returned by Uid and Username when a user
+// does not have sufficient permissions to access the requested file or folder.
+//
+// It contains data about the file for which the permission check failed,
+// which can be different from the requested file.
+type PermissionError struct {
+	// path of the file/folder for which the permission check failed
+	File string
+	// permissions of the file
+	FileMode os.FileMode
+	// uid of the file
+	FileUid int
+	// gid of the file
+	FileGid int
+	// uid of the user whose permission is checked
+	Uid int
+	// gid of the premiary and all secondary groups of the user whose permission is checked
+	Gid []int
+	// permissions requested for the file (can be different from the one requested in Uid or Username)
+	WantMode os.FileMode
+	// permissions requested for the file that the user is missing, for the permission class
+	// (owner, group or other) that applies to the user
+	MissingMode os.FileMode
+	// path of the requested file/folder, with all symlinks resolved (can be empty if the path
+	// could not be fully resolved, for example if it does not exist)
+	//
+	// If the user cannot search a folder on the path, the rest of the path is still resolved, with
+	// the privileges of the calling process rather than those of the user, so that ResolvedPath
+	// can reveal symlink targets and files the user cannot see: do not show it to the user unless
+	// they are allowed to see them.
+	ResolvedPath string
+}
+
+func (p *PermissionError) Error() string {
+	return fmt.Sprintf("unsufficient permissions of user (uid %d, user groups %v) for file [%s] (uid %d, gid %d): want mode %o, file has mode %o, missing mode %o", p.Uid, p.Gid, p.File, p.FileUid, p.FileGid, p.WantMode, p.FileMode, p.MissingMode)
+}
+
+// Is reports whether target is ErrPermission or fs.ErrPermission, so that permission errors
+// can be detected with errors.Is.
+func (p *PermissionError) Is(target error) bool {
+	return target == ErrPermission || target == fs.ErrPermission
+}
+
+// StickyError is returned by CanDelete when a user does not own a file nor the
+// directory containing it, and the directory has the sticky bit set.
+//
+// In that case, the user cannot delete or rename the file, even if it has the
+// permissions to write to the directory.
+type StickyError struct {
+	// path of the file
+	File string
+	// uid of the file
+	FileUid int
+	// path of the directory containing the file
+	Dir string
+	// uid of the directory
+	DirUid int
+	// uid of the user whose permission is checked
+	Uid int
+}
+
+func (p *StickyError) Error() string {
+	return fmt.Sprintf("unsufficient permissions of user (uid %d) for deleting file [%s] (uid %d) in sticky directory [%s] (uid %d)", p.Uid, p.File, p.FileUid, p.Dir, p.DirUid)
+}
+
+// Uid checks whether a user has the permissions to access a file.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned
+//   error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func Uid(uid int, mode os.FileMode, path string) error {
+	return defaultChecker.Uid(uid, mode, path)
+}
+
+// UidContext is like Uid, but aborts the check as soon as ctx is done, in which case
+// the error of the context is returned.
+//
+// This is useful to bound the duration of a check on slow (for example network) filesystems.
+func UidContext(ctx context.Context, uid int, mode os.FileMode, path string) error {
+	return defaultChecker.UidContext(ctx, uid, mode, path)
+}
+
+// UidAt checks whether a user has the permissions to access a file, resolving relative paths against a base directory.
+//
+// It behaves like Uid, except that if path is relative, it is joined to base rather than to
+// the current working directory of the process. If base is empty, the current working
+// directory is used.
+//
+// - base is the path of the directory relative paths are resolved against
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder, absolute or relative to base
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func UidAt(base string, uid int, mode os.FileMode, path string) error {
+	if base != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	return Uid(uid, mode, path)
+}
+
+// UidHome checks whether a user has the permissions to access a file of its home directory.
+//
+// It behaves like Uid, except that path is relative to the home directory of the user, as
+// found in its passwd(5) entry, like ~/path would be expanded by a shell for the user. Absolute
+// paths are rejected rather than treated as-is, since they are not relative to the home
+// directory. path is not confined to the home directory: it may escape it with .. components
+// or symlinks, see CheckWithin.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - rel is the path of the file/folder, relative to the home directory of the user, for example ".config/app"
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if rel is absolute, if the user does not exist (in which case the returned error is a UnknownUserIdError) or has no home directory, or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func UidHome(uid int, mode os.FileMode, rel string) error {
+	return defaultChecker.UidHome(uid, mode, rel)
+}
+
+// UidHome is like the package-level UidHome, using the options of the Checker.
+func (c *Checker) UidHome(uid int, mode os.FileMode, rel string) error {
+	if filepath.IsAbs(rel) {
+		return errors.New("access: path is not relative to the home directory: " + rel)
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return err
+	}
+	if u.HomeDir == "" {
+		return errors.New("access: user has no home directory: " + u.Username)
+	}
+	id, err := c.identityOf(u)
+	if err != nil {
+		return err
+	}
+	return c.check(context.Background(), id.Uid, id.Gids, mode, filepath.Join(u.HomeDir, rel), true)
+}
+
+// UidCanonical checks whether a user has the permissions to access a file, rejecting non-canonical paths.
+//
+// It behaves like Uid, except that path must be absolute and already clean (see filepath.Clean):
+// paths with . or .. components, repeated separators or a trailing separator are rejected with a
+// NonCanonicalPathError before any filesystem access, rather than silently cleaned.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the absolute, clean path of the file/folder
+//
+// - returns a NonCanonicalPathError if path is not absolute and clean
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func UidCanonical(uid int, mode os.FileMode, path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return &NonCanonicalPathError{Path: path}
+	}
+	return Uid(uid, mode, path)
+}
+
+// UidNoFollow checks whether a user has the permissions to access a file, without following a final symlink.
+//
+// It behaves like Uid, except that if the final component of path is a symlink, mode is checked
+// on the symlink itself rather than on its target, like lstat(2) or readlink(2) would. Symlinks in
+// the other components of path are still resolved.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func UidNoFollow(uid int, mode os.FileMode, path string) error {
+	return defaultChecker.UidNoFollow(uid, mode, path)
+}
+
+// UidNoFollow is like the package-level UidNoFollow, using the options of the Checker.
+func (c *Checker) UidNoFollow(uid int, mode os.FileMode, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.check(context.Background(), id.Uid, id.Gids, mode, path, false)
+}
+
+// Username checks whether a user has the permissions to access a file.
+//
+// - username is the *nix username of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned
+//   error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func Username(username string, mode os.FileMode, path string) error {
+	return defaultChecker.Username(username, mode, path)
+}
+
+// User checks whether an already looked up user has the permissions to access a file.
+//
+// It behaves like Username, but skips the user lookup, which is useful when checking
+// many files for the same user.
+//
+// - u is the *nix user, as returned by user.Lookup or user.LookupId
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the uid of the user cannot be parsed, or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func User(u *user.User, mode os.FileMode, path string) error {
+	return defaultChecker.User(u, mode, path)
+}
+
+// Groupname checks whether any member of a group has the permissions to access a file, through
+// its group membership alone.
+//
+// The caller is treated as a hypothetical user whose only group is the given group, and who
+// owns no file: only the group and other permission bits (and POSIX ACL group entries, if
+// enabled) are considered, on the file and all its ancestors. In a returned PermissionError,
+// Uid is -1.
+//
+// - group is the *nix group name
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the members of the group do not have the requested access to the file
+//
+// - returns a non-nil error if the group does not exist (in which case the returned error is a UnknownGroupError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the members of the group have the requested access to the file
+func Groupname(group string, mode os.FileMode, path string) error {
+	return defaultChecker.Groupname(group, mode, path)
+}
+
+// Groupname is like the package-level Groupname, using the options of the Checker.
+func (c *Checker) Groupname(group string, mode os.FileMode, path string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return err
+	}
+	gid, err := parseId("gid", g.Gid)
+	if err != nil {
+		return err
+	}
+	return c.group(gid, mode, path)
+}
+
+// group checks the access of a hypothetical member of a group, with no uid
+func (c *Checker) group(gid int, mode os.FileMode, path string) error {
+	return c.check(context.Background(), -1, []int{gid}, mode, path, true)
+}
+
+// PublicAccess checks whether any user, even one unrelated to the file and its ancestors, has the permissions to access a file.
+//
+// Only the permission bits of the "other" class are consulted, regardless of the owners and
+// groups of the files: the search bit of the other class on each ancestor, and the bits of mode
+// on the file. Symlinks are resolved like Uid does, as a user with no uid and no groups.
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError (with Uid -1) if the other users do not have the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, any user has the requested access to the file
+func PublicAccess(mode os.FileMode, path string) error {
+	return defaultChecker.PublicAccess(mode, path)
+}
+
+// PublicAccess is like the package-level PublicAccess, using the options of the Checker.
+func (c *Checker) PublicAccess(mode os.FileMode, path string) error {
+	return c.publicAccess(mode, path)
+}
+
+// publicAccess checks the access of a hypothetical user with no uid and no groups
+func (c *Checker) publicAccess(mode os.FileMode, path string) error {
+	return c.check(context.Background(), -1, nil, mode, path, true)
+}
+
+// ReadableUid checks whether a user has the permission to read a file.
+//
+// It is a shorthand for Uid(uid, Read, path).
+func ReadableUid(uid int, path string) error {
+	return Uid(uid, Read, path)
+}
+
+// WritableUid checks whether a user has the permission to write a file.
+//
+// It is a shorthand for Uid(uid, Write, path).
+func WritableUid(uid int, path string) error {
+	return Uid(uid, Write, path)
+}
+
+// ExecutableUid checks whether a user has the permission to execute a file (or search a folder).
+//
+// It is a shorthand for Uid(uid, Execute, path).
+func ExecutableUid(uid int, path string) error {
+	return Uid(uid, Execute, path)
+}
+
+// ReadableUsername checks whether a user has the permission to read a file.
+//
+// It is a shorthand for Username(username, Read, path).
+func ReadableUsername(username string, path string) error {
+	return Username(username, Read, path)
+}
+
+// WritableUsername checks whether a user has the permission to write a file.
+//
+// It is a shorthand for Username(username, Write, path).
+func WritableUsername(username string, path string) error {
+	return Username(username, Write, path)
+}
+
+// ExecutableUsername checks whether a user has the permission to execute a file (or search a folder).
+//
+// It is a shorthand for Username(username, Execute, path).
+func ExecutableUsername(username string, path string) error {
+	return Username(username, Execute, path)
+}
+
+// Current checks whether the current user has the permissions to access a file.
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the current user cannot be determined (in which case the error of user.Current is returned unchanged), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func Current(mode os.FileMode, path string) error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	return User(u, mode, path)
+}
+
+// CanUid reports whether a user has the permissions to access a file.
+//
+// It behaves like Uid, but returns false and a nil error instead of a PermissionError
+// when the user does not have the requested access to the file.
+//
+// - returns true and a nil error if the user has the requested access to the file
+//
+// - returns false and a nil error if the user does not have the requested access to the file
+//
+// - returns false and a non-nil error if the user does not exist, or if an underlying error occurs when reading permissions
+func CanUid(uid int, mode os.FileMode, path string) (bool, error) {
+	return can(Uid(uid, mode, path))
+}
+
+// CanUsername reports whether a user has the permissions to access a file.
+//
+// It behaves like Username, but returns false and a nil error instead of a PermissionError
+// when the user does not have the requested access to the file.
+//
+// - returns true and a nil error if the user has the requested access to the file
+//
+// - returns false and a nil error if the user does not have the requested access to the file
+//
+// - returns false and a non-nil error if the user does not exist, or if an underlying error occurs when reading permissions
+func CanUsername(username string, mode os.FileMode, path string) (bool, error) {
+	return can(Username(username, mode, path))
+}
+
+func can(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	var pe *PermissionError
+	if errors.As(err, &pe) {
+		return false, nil
+	}
+	return false, err
+}
+
+// CanDelete checks whether a user has the permissions to delete (or rename) a file.
+//
+// Deleting a file requires write and execute permissions on the directory containing it,
+// but no permissions on the file itself. If the final component of path is a symlink, the
+// symlink itself is deleted rather than its target.
+//
+// If the directory has the sticky bit set (like /tmp), the user must additionally own either
+// the file or the directory.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access to the directory containing the file
+//
+// - returns a StickyError if the user does not own the file nor its sticky directory
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can delete the file
+func CanDelete(uid int, path string) error {
+	return defaultChecker.CanDelete(uid, path)
+}
+
+// CanDelete is like the package-level CanDelete, using the options of the Checker.
+func (c *Checker) CanDelete(uid int, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.canDelete(id.Uid, id.Gids, path)
+}
+
+func (c *Checker) canDelete(uid int, gids []int, path string) error {
+	id := Identity{Uid: uid, Gids: gids}
+	return c.canDeleteUser(id, id, path)
+}
+
+// dir is the resolved directory containing file
+func (w *walk) checkSticky(dir string, file string) error {
+	dfi, err := w.lstat(dir)
+	if err != nil {
+		return err
+	}
+	ffi, err := w.lstat(file)
+	if err != nil {
+		return err
+	}
+	uid := w.realUid
+	if uid == 0 || dfi.Mode()&os.ModeSticky == 0 {
+		return nil
+	}
+	dst, err := statOf(dfi)
+	if err != nil {
+		return err
+	}
+	fst, err := statOf(ffi)
+	if err != nil {
+		return err
+	}
+	dirUid, fileUid := dst.uid, fst.uid
+	if uid == dirUid || uid == fileUid {
+		return nil
+	}
+	return &StickyError{
+		File:    file,
+		FileUid: fileUid,
+		Dir:     dir,
+		DirUid:  dirUid,
+		Uid:     uid,
+	}
+}
+
+// Creation is the predicted outcome of the creation of a file, as returned by CanCreate.
+type Creation struct {
+	// gid of the created file: the gid of the directory containing it if the directory has
+	// the setgid bit set, otherwise the primary gid of the user
+	Gid int
+	// permissions of the created file, if it is created with mode 0666 (like os.Create does):
+	// derived from the default ACL of the directory if DefaultACL is set, otherwise 0666 with
+	// the umask of the Checker applied (see WithUmask and EffectiveCreateMode)
+	Mode os.FileMode
+	// whether the directory has a POSIX default ACL (only read if POSIX ACLs are honored, see
+	// WithPOSIXACL): the created file inherits it as its access ACL, and the umask is ignored
+	DefaultACL bool
+}
+
+// CanCreate checks whether a user has the permissions to create a file, and predicts the
+// outcome of the creation.
+//
+// Creating a file requires write and execute permissions on the directory containing it.
+// Whether the file already exists is not checked.
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - path is the path of the file/folder to create
+//
+// - returns a PermissionError if the user does not have access to the directory containing the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can create the file, and the returned Creation describes the file that would be created
+func CanCreate(uid int, gids []int, path string) (Creation, error) {
+	return defaultChecker.CanCreate(uid, gids, path)
+}
+
+// CanCreate is like the package-level CanCreate, using the options of the Checker.
+func (c *Checker) CanCreate(uid int, gids []int, path string) (Creation, error) {
+	return c.canCreate(uid, gids, path)
+}
+
+func (c *Checker) canCreate(uid int, gids []int, path string) (Creation, error) {
+	if len(gids) == 0 {
+		return Creation{}, errors.New("access: missing primary group")
+	}
+	path, err := absPath(path)
+	if err != nil {
+		return Creation{}, err
+	}
+	dir, name := filepath.Split(path)
+	if name == "" {
+		return Creation{}, errors.New("access: cannot create root directory: " + path)
+	}
+
+	w := c.newWalk(context.Background(), uid, gids)
+	dir, err = w.resolveDir(dir)
+	if err != nil {
+		return Creation{}, err
+	}
+	if err := w.checkPath(Write|Execute, dir); err != nil {
+		return Creation{}, err
+	}
+
+	fi, err := w.lstat(dir)
+	if err != nil {
+		return Creation{}, err
+	}
+	gid := gids[0]
+	if fi.Mode()&os.ModeSetgid != 0 {
+		st, err := statOf(fi)
+		if err != nil {
+			return Creation{}, err
+		}
+		gid = st.gid
+	}
+	mode, defaultACL := os.FileMode(0666), false
+	if c.posixACL {
+		a, err := w.posixDefaultACL(dir, fi)
+		if err != nil {
+			return Creation{}, err
+		}
+		if a != nil {
+			mode, defaultACL = a.createMode(mode), true
+		}
+	}
+	if !defaultACL {
+		mode = EffectiveCreateMode(mode, c.umask)
+	}
+	return Creation{
+		Gid:        gid,
+		Mode:       mode,
+		DefaultACL: defaultACL,
+	}, nil
+}
+
+// EffectiveCreateMode returns the permissions of a file created with mode base by a process
+// whose umask is umask, like open(2) and mkdir(2) compute them when the directory containing
+// the file has no POSIX default ACL: the bits set in umask are cleared from base.
+//
+// The umask of a process cannot be read reliably by another process, so it must be supplied
+// by the caller, for example 0022. Only the permission bits of umask are used.
+func EffectiveCreateMode(base os.FileMode, umask os.FileMode) os.FileMode {
+	return base &^ (umask & os.ModePerm)
+}
+
+// CanCreateFile checks whether a user has the permissions to open a file for writing, creating
+// it if it does not exist.
+//
+// If the file does not exist, creating it requires write and execute permissions on the
+// directory containing it. If it already exists, writing it requires write permission on the
+// file itself, after following symlinks.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file to create or write
+//
+// - returns a PermissionError if the user cannot create the file, or write the existing file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can create or write the file
+func CanCreateFile(uid int, path string) error {
+	return defaultChecker.CanCreateFile(uid, path)
+}
+
+// CanCreateFile is like the package-level CanCreateFile, using the options of the Checker.
+func (c *Checker) CanCreateFile(uid int, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.canCreateFile(id.Uid, id.Gids, path)
+}
+
+func (c *Checker) canCreateFile(uid int, gids []int, path string) error {
+	path, err := absPath(path)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	if name == "" {
+		return errors.New("access: cannot create root directory: " + path)
+	}
+
+	w := c.newWalk(context.Background(), uid, gids)
+	dir, err = w.resolveDir(dir)
+	if err != nil {
+		return err
+	}
+	// whether the file exists can only be known by searching its directory
+	if err := w.checkPath(Execute, dir); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, name)
+	if _, err := w.lstat(file); os.IsNotExist(err) {
+		return w.checkPath(Write|Execute, dir)
+	} else if err != nil {
+		return err
+	}
+	dest, err := w.resolve(file, true)
+	if err != nil {
+		return err
+	}
+	return w.checkPath(Write, dest)
+}
+
+// Mode returns the permissions a user effectively has on a file.
+//
+// The returned mode is a combination of Read, Write and Execute, accounting for the
+// ownership of the file, the groups of the user, and the permissions of the
+// directories containing the file.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - returns zero and a nil error if the user cannot even access the directories containing the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func Mode(uid int, path string) (os.FileMode, error) {
+	return defaultChecker.Mode(uid, path)
+}
+
+// Mode is like the package-level Mode, using the options of the Checker.
+func (c *Checker) Mode(uid int, path string) (os.FileMode, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return 0, err
+	}
+	return c.mode(id.Uid, id.Gids, path)
+}
+
+func (c *Checker) mode(uid int, gids []int, path string) (os.FileMode, error) {
+	mode, err := c.checkAny(uid, gids, []os.FileMode{Read, Write, Execute}, path)
+	var pe *PermissionError
+	if errors.As(err, &pe) {
+		return 0, nil
+	}
+	return mode, err
+}
+
+// CheckAny checks whether a user identified by its uid and group ids has any of several permissions on a file.
+//
+// Unlike Check, which requires all the permissions of its mode, CheckAny returns which of the
+// requested modes the user has, reading the permissions of the file only once.
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - modes are the requested permissions on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns the combination of the modes the user has, and a nil error if the user has at least one of them
+//
+// - returns a PermissionError if the user has none of the requested modes
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+func CheckAny(uid int, gids []int, modes []os.FileMode, path string) (os.FileMode, error) {
+	return defaultChecker.CheckAny(uid, gids, modes, path)
+}
+
+// CheckAny is like the package-level CheckAny, using the options of the Checker.
+func (c *Checker) CheckAny(uid int, gids []int, modes []os.FileMode, path string) (os.FileMode, error) {
+	return c.checkAny(uid, gids, modes, path)
+}
+
+func (c *Checker) checkAny(uid int, gids []int, modes []os.FileMode, path string) (os.FileMode, error) {
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var mode os.FileMode
+	var denied error
+	for _, m := range modes {
+		err := w.checkPath(m, dest)
+		var pe *PermissionError
+		if err == nil {
+			mode |= m
+		} else if errors.As(err, &pe) {
+			if denied == nil {
+				denied = err
+			}
+		} else {
+			return 0, err
+		}
+	}
+	if mode == 0 && denied != nil {
+		return 0, denied
+	}
+	return mode, nil
+}
+
+func contains(a []int, i int) bool {
+	for _, e := range a {
+		if e == i {
+			return true
+		}
+	}
+	return false
+}
+
+// classMode returns the permissions of the permission class (owner, group or other)
+// of fm that applies to the user, shifted to the other bits
+func classMode(fm os.FileMode, uid int, gid []int, fileUid int, fileGid int) os.FileMode {
+	if uid == fileUid {
+		return (fm >> 6) & 7
+	}
+	if contains(gid, fileGid) {
+		return (fm >> 3) & 7
+	}
+	return fm & 7
+}
+
+// sysStat is the platform-independent subset of the system-specific stat data of a file
+type sysStat struct {
+	uid int
+	gid int
+	dev uint64
+	ino uint64
+	// number of hard links (st_nlink)
+	nlink uint64
+	// st_flags, on BSDs (zero on other platforms)
+	flags uint32
+}
+
+// walk holds the state of a single check of a user
+type walk struct {
+	c    *Checker
+	ctx  context.Context
+	uid  int
+	gids []int
+	// real uid of the user, consulted for the sticky directory rules, see CheckUser
+	realUid int
+	// number of symlinks followed and of components walked by the last resolution
+	linksWalked int
+	components  int
+	// resolved absolute path that the resolved paths must not escape, or empty, see CheckWithin
+	root string
+	// capabilities of the user, see CheckCaps
+	caps uint64
+	// FileInfo of the files already stat'ed during the check, so that each file is stat'ed at most once
+	stats map[string]os.FileInfo
+	// directories whose search permission was already checked, along with all their ancestors
+	searched map[string]bool
+	// if set, called for each permission decision, see Trace
+	onStep func(Step) error
+	// if set, permission denials do not abort the check and are collected in denials instead
+	all     bool
+	denials []*PermissionError
+}
+
+func (c *Checker) newWalk(ctx context.Context, uid int, gids []int) *walk {
+	var caps uint64
+	if uid == 0 {
+		caps = rootCaps
+	}
+	if c.primaryGroupOnly && len(gids) > 1 {
+		// the supplementary groups are ignored, see WithPrimaryGroupOnly
+		gids = gids[:1]
+	}
+	if c.maxGroups >= 0 && len(gids) > 1+c.maxGroups {
+		// the groups beyond the limit are ignored, see WithMaxGroups
+		gids = gids[:1+c.maxGroups]
+	}
+	return &walk{
+		c:        c,
+		ctx:      ctx,
+		uid:      uid,
+		gids:     gids,
+		caps:     caps,
+		stats:    make(map[string]os.FileInfo),
+		searched: make(map[string]bool),
+	}
+}
+
+func (w *walk) lstat(path string) (os.FileInfo, error) {
+	if fi, ok := w.stats[path]; ok {
+		return fi, nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	fi, err := w.c.fs.Lstat(path)
+	if errors.Is(err, fs.ErrPermission) {
+		return nil, &CheckerDeniedError{Path: path, Err: err}
+	} else if err != nil {
+		return nil, err
+	}
+	w.stats[path] = fi
+	return fi, nil
+}
+
+func (w *walk) readlink(path string) (string, error) {
+	if err := w.ctx.Err(); err != nil {
+		return "", err
+	}
+	link, err := w.c.fs.Readlink(path)
+	if errors.Is(err, fs.ErrPermission) {
+		return "", &CheckerDeniedError{Path: path, Err: err}
+	}
+	return link, err
+}
+
+func (w *walk) checkReadOnly(path string) error {
+	sfs, ok := w.c.fs.(StatfsFileSystem)
+	if !ok {
+		return ErrUnsupported
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	info, err := sfs.Statfs(path)
+	if err != nil {
+		return err
+	}
+	if info.ReadOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnlyFS, path)
+	}
+	return nil
+}
+
+// pseudo-filesystem types, as reported in FSInfo, see WithRejectSpecialFS
+var specialFSTypes = map[string]bool{
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devfs":       true,
+	"devpts":      true,
+	"efivarfs":    true,
+	"fdescfs":     true,
+	"linprocfs":   true,
+	"linsysfs":    true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"procfs":      true,
+	"pstore":      true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+func (w *walk) checkSpecialFS(path string) error {
+	sfs, ok := w.c.fs.(StatfsFileSystem)
+	if !ok {
+		return ErrUnsupported
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	info, err := sfs.Statfs(path)
+	if err != nil {
+		return err
+	}
+	if specialFSTypes[info.Type] {
+		return &SpecialFSError{Path: path, FSType: info.Type}
+	}
+	return nil
+}
+
+func (w *walk) checkAttrs(path string) error {
+	afs, ok := w.c.fs.(AttrFileSystem)
+	if !ok {
+		return ErrUnsupported
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	attrs, err := afs.Attrs(path)
+	if err != nil {
+		return err
+	}
+	if attrs.Immutable {
+		return fmt.Errorf("%w: %s", ErrImmutable, path)
+	}
+	if attrs.AppendOnly {
+		return fmt.Errorf("%w: %s", ErrAppendOnly, path)
+	}
+	return nil
+}
+
+// denies reports whether the permission bits of a file deny need to the user; only the bits of
+// the class of the user are consulted: an owner denied by the owner bits is not granted access by
+// the group or other bits, even if they are more permissive
+func (w *walk) denies(fm os.FileMode, st sysStat, need os.FileMode) bool {
+	return need != 0 && classMode(fm, w.uid, w.gids, st.uid, st.gid)&need != need
+}
+
+// permissionError returns the error for a file denying mode (need, after capabilities) to the user
+func (w *walk) permissionError(path string, resolved string, fm os.FileMode, st sysStat, mode os.FileMode, need os.FileMode) *PermissionError {
+	return &PermissionError{
+		File:         path,
+		FileMode:     fm,
+		FileUid:      st.uid,
+		FileGid:      st.gid,
+		Uid:          w.uid,
+		Gid:          w.gids,
+		WantMode:     mode,
+		MissingMode:  need &^ classMode(fm, w.uid, w.gids, st.uid, st.gid),
+		ResolvedPath: resolved,
+	}
+}
+
+// checkMode returns an error if mode is not a combination of Read, Write and Execute
+func checkMode(mode os.FileMode) error {
+	if mode&^(Read|Write|Execute) != 0 {
+		return fmt.Errorf("%w: %#o is not a combination of Read, Write and Execute", ErrInvalidMode, uint32(mode))
+	}
+	return nil
+}
+
+// searchDir checks that dir, the directory containing a file being accessed, can be searched
+func (w *walk) searchDir(dir string) error {
+	if w.c.trustedRoot != "" && within(dir, w.c.trustedRoot) {
+		// the trusted root and its ancestors are known to be searchable, see WithTrustedRoot
+		return nil
+	}
+	return w.checkPath(Search, dir)
+}
+
+// path is absolute, contains no . or ..
+func (w *walk) checkPath(mode os.FileMode, path string) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	uid, gid := w.uid, w.gids
+	resolved := path
+	var searched []string
+	for i := 0; len(path) > 0; i++ {
+		if mode == Search && w.searched[path] {
+			break
+		}
+		if i > 0 && w.c.trustedRoot != "" && within(path, w.c.trustedRoot) {
+			// the trusted root and its ancestors are known to be searchable, see WithTrustedRoot,
+			// but the requested permission is still checked on the requested file itself
+			break
+		}
+		fi, err := w.lstat(path)
+		if err != nil {
+			return err
+		}
+		fm := fi.Mode()
+		st, err := statOf(fi)
+		if err != nil {
+			return err
+		}
+		fileUid, fileGid := st.uid, st.gid
+
+		need := w.override(fm, mode)
+		denied := w.denies(fm, st, need)
+		// with an ACL, the group bits of the mode are its mask, and its named entries take
+		// precedence over the group and other bits: the ACL decides for all but the owner
+		if w.c.posixACL && need != 0 && (denied || uid != fileUid) {
+			a, err := w.posixACL(path, fi)
+			if err != nil {
+				return err
+			}
+			if a != nil {
+				denied = !a.grants(uid, gid, fileUid, fileGid, need)
+			}
+		}
+		if denied && w.c.nfs4ACL {
+			a, err := w.nfs4ACL(path, fi)
+			if err != nil {
+				return err
+			}
+			if a != nil && a.grants(uid, gid, fileUid, fileGid, need) {
+				denied = false
+			}
+		}
+		if w.onStep != nil {
+			err := w.onStep(Step{
+				Path:         path,
+				RequiredMode: mode,
+				FileMode:     fm,
+				FileUid:      fileUid,
+				FileGid:      fileGid,
+				Granted:      !denied,
+				LinksWalked:  w.linksWalked,
+				Dev:          st.dev,
+				Ino:          st.ino,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if denied {
+			pe := w.permissionError(path, resolved, fm, st, mode, need)
+			if !w.all {
+				return pe
+			}
+			w.denials = append(w.denials, pe)
+		}
+		// like the kernel, FIFOs, sockets and device nodes can be written on a read-only mount
+		if mode&Write != 0 && w.c.readOnlyCheck && fm&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice) == 0 {
+			if err := w.checkReadOnly(path); err != nil {
+				return err
+			}
+		}
+		if mode&Write != 0 && w.c.attrCheck && (fm.IsRegular() || fm.IsDir()) {
+			if err := w.checkAttrs(path); err != nil {
+				return err
+			}
+		}
+		if mode&Write != 0 && w.c.rejectMultiplyLinked && fm.IsRegular() && st.nlink > 1 {
+			return fmt.Errorf("%w: %s has %d links", ErrMultiplyLinked, path, st.nlink)
+		}
+		if mode == Search {
+			searched = append(searched, path)
+		}
+		mode = Search
+
+		if path == string(os.PathSeparator) {
+			// the root directory has no parent
+			break
+		}
+		i := strings.LastIndexFunc(path, func(r rune) bool {
+			return r == os.PathSeparator
+		})
+		if i < 0 { // should never happen
+			return errors.New("absolute path not containing any slash: " + path)
+		}
+		if i == 0 {
+			// keep the separator: the parent of /a is /, which must be checked too
+			i++
+		}
+		path = path[:i]
+	}
+	for _, p := range searched {
+		w.searched[p] = true
+	}
+	return nil
+}
+
+// Check checks whether a user identified by its uid and group ids has the permissions to access a file.
+//
+// Unlike Uid and Username, it does not look up the user or its groups, which is useful when
+// the identity of the user is already known, for example from the kernel.
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func Check(uid int, gids []int, mode os.FileMode, path string) error {
+	return defaultChecker.Check(uid, gids, mode, path)
+}
+
+// CheckWithGroups checks whether a user identified by its uid has the permissions to access a
+// file, when running with a specific set of groups.
+//
+// It is identical to Check(uid, gids, mode, path), and is meant for the cases where the groups
+// of a process differ from the groups of its user in the user database, as Uid looks them up:
+// for example when a command is run with sg(1), newgrp(1), or sudo -g, or by a process that
+// changed its groups with setgroups(2). gids must then be the exact group set of the process,
+// for example the user's groups with an additional "deploy" group, to model "what if alice runs
+// this with the deploy group active".
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids the process runs with: the first element is treated as the primary (effective) group, the others as the supplementary groups
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func CheckWithGroups(uid int, gids []int, mode os.FileMode, path string) error {
+	return defaultChecker.Check(uid, gids, mode, path)
+}
+
+// CheckFS checks whether a user identified by its uid and group ids has the permissions to access a file of a custom filesystem.
+//
+// It behaves like Check, but reads permissions and symlinks from fsys rather than from the
+// OS, which is useful for testing and for virtual filesystems. Relative paths are still made
+// absolute with the current working directory of the process.
+func CheckFS(fsys FileSystem, uid int, gids []int, mode os.FileMode, path string) error {
+	return New(WithFileSystem(fsys)).Check(uid, gids, mode, path)
+}
+
+// CheckBatch checks whether a user identified by its uid and group ids has the permissions to access many files.
+//
+// It behaves like calling Check for each path, but the permissions of the directories shared by
+// the paths are only read and checked once, which is faster when the paths have common ancestors.
+//
+// The returned slice has the same length as paths: each element is the error Check would return
+// for the path at the same index, nil if the user has the requested access to it.
+func CheckBatch(uid int, gids []int, mode os.FileMode, paths []string) []error {
+	return defaultChecker.CheckBatch(uid, gids, mode, paths)
+}
+
+// CheckBatch is like the package-level CheckBatch, using the options of the Checker.
+func (c *Checker) CheckBatch(uid int, gids []int, mode os.FileMode, paths []string) []error {
+	return c.checkBatch(uid, gids, mode, paths)
+}
 
-// ErrNoProgress is returned by some clients of an io.Reader when
-// many calls to Read have failed to return any data or error,
-// usually the sign of a broken io.Reader implementation.
+func (c *Checker) checkBatch(uid int, gids []int, mode os.FileMode, paths []string) []error {
+	w := c.newWalk(context.Background(), uid, gids)
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		dest, err := w.resolve(path, true)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = w.checkPath(mode, dest)
+	}
+	return errs
+}
 
-// PermissionError is returned by Uid and Username when a user
-// does not have sufficient permissions to access the requested file or folder.
+// CheckUsers checks whether several users identified by their uids have the permissions to access a file.
 //
-// It contains data about the file for which the permission check failed,
-// which can be different from the requested file.
-type PermissionError struct {
-	// path of the file/folder for which the permission check failed
-	File string
-	// permissions of the file
-	FileMode os.FileMode
-	// uid of the file
-	FileUid int
-	// gid of the file
-	FileGid int
-	// uid of the user whose permission is checked
-	Uid int
-	// gid of the premiary and all secondary groups of the user whose permission is checked
-	Gid []int
-	// permissions requested for the file (can be different from the one requested in Uid or Username)
-	WantMode os.FileMode
+// It behaves like calling Uid for each user, but the file and its ancestors are only read once,
+// and then checked against the identity of each user.
+//
+// - uids are the *nix uids of the users
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a map from each uid to the error Uid would return for the user, nil if the user has the requested access to the file
+func CheckUsers(uids []int, mode os.FileMode, path string) map[int]error {
+	return defaultChecker.CheckUsers(uids, mode, path)
 }
 
-func (p *PermissionError) Error() string {
-	return fmt.Sprintf("unsufficient permissions of user (uid %d, gid %d) for file [%s] (uid %d, gid %d): want mode %o, file has mode %o", p.Uid, p.Gid, p.File, p.FileUid, p.FileGid, p.WantMode, p.FileMode)
+// CheckUsers is like the package-level CheckUsers, using the options of the Checker.
+func (c *Checker) CheckUsers(uids []int, mode os.FileMode, path string) map[int]error {
+	errs := make(map[int]error, len(uids))
+	ids := make([]Identity, 0, len(uids))
+	for _, uid := range uids {
+		id, err := c.IdentityForUid(uid)
+		if err != nil {
+			errs[uid] = err
+			continue
+		}
+		ids = append(ids, id)
+	}
+	for uid, err := range c.checkUsers(ids, mode, path) {
+		errs[uid] = err
+	}
+	return errs
 }
 
-// Uid checks whether a user has the permissions to access a file.
+func (c *Checker) checkUsers(ids []Identity, mode os.FileMode, path string) map[int]error {
+	// the walks of the users share their stats, so that each file is stat'ed at most once
+	stats := make(map[string]os.FileInfo)
+	errs := make(map[int]error, len(ids))
+	for _, id := range ids {
+		w := c.newWalk(context.Background(), id.Uid, id.Gids)
+		w.stats = stats
+		dest, err := w.resolve(path, true)
+		if err != nil {
+			errs[id.Uid] = err
+			continue
+		}
+		errs[id.Uid] = w.checkPath(mode, dest)
+	}
+	return errs
+}
+
+// CheckAll checks whether a user identified by its uid has the permissions to access a file,
+// reporting every denied file on the path.
+//
+// Unlike Uid, which stops at the first denial, CheckAll keeps resolving the path and checking
+// the permissions of the file and all its ancestors, and returns all the denials, joined with
+// errors.Join. Each denial can be enumerated with errors.As, or by unwrapping the returned
+// error with its Unwrap() []error method.
 //
 // - uid is the *nix uid of the user
 //
@@ -62,106 +1556,385 @@ func (p *PermissionError) Error() string {
 //
 // - path is the path of the file/folder
 //
-// - returns a PermissionError if the user does not have access the requested access to the file
+// - returns the PermissionErrors, joined, if the user does not have the requested access to the file
 //
-// - returns a non-nil error if the user does not exist (in which case the returned
-//   error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
 //
 // - if the error is nil, the user has the requested access to the file
-func Uid(uid int, mode os.FileMode, path string) error {
-	u, err := user.LookupId(strconv.Itoa(uid))
+func CheckAll(uid int, mode os.FileMode, path string) error {
+	return defaultChecker.CheckAll(uid, mode, path)
+}
+
+// CheckAll is like the package-level CheckAll, using the options of the Checker.
+func (c *Checker) CheckAll(uid int, mode os.FileMode, path string) error {
+	id, err := c.IdentityForUid(uid)
 	if err != nil {
 		return err
 	}
-	return access(u, uid, mode, path)
+	return c.checkAll(id.Uid, id.Gids, mode, path)
 }
 
-// Username checks whether a user has the permissions to access a file.
+func (c *Checker) checkAll(uid int, gids []int, mode os.FileMode, path string) error {
+	w := c.newWalk(context.Background(), uid, gids)
+	w.all = true
+	if err := w.checkCollect(mode, path); err != nil {
+		return err
+	}
+	errs := make([]error, len(w.denials))
+	for i, pe := range w.denials {
+		errs[i] = pe
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Checker) check(ctx context.Context, uid int, gids []int, mode os.FileMode, path string, follow bool) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	for retries := 0; ; retries++ {
+		w := c.newWalk(ctx, uid, gids)
+		dest, err := w.resolve(path, follow)
+		if err != nil {
+			return err
+		}
+
+		// all symlinks resolved, check access on final path
+		err = w.checkResolvedPath(mode, dest)
+		if c.consistencyRetries <= 0 {
+			return err
+		}
+		// see WithConsistencyRetries
+		changed, serr := w.changed(dest)
+		if serr != nil {
+			return serr
+		}
+		if !changed {
+			return err
+		}
+		if retries == c.consistencyRetries {
+			return fmt.Errorf("%w: %s changed %d times", ErrRaced, dest, retries+1)
+		}
+	}
+}
+
+// checkResolvedPath is like checkPath, with the decision cache of the Checker, see WithDecisionCache
+func (w *walk) checkResolvedPath(mode os.FileMode, dest string) error {
+	if w.c.decisions == nil {
+		return w.checkPath(mode, dest)
+	}
+	key := newDecisionKey(w.uid, w.gids, mode, dest)
+	if pe, ok := w.c.decisions.get(key, time.Now()); ok {
+		if pe != nil {
+			return pe
+		}
+		return nil
+	}
+	err := w.checkPath(mode, dest)
+	if pe, ok := err.(*PermissionError); ok || err == nil {
+		w.c.decisions.put(key, pe, time.Now())
+	}
+	return err
+}
+
+// changed reports whether the file at path, as stat'ed by the walk, was replaced since
+func (w *walk) changed(path string) (bool, error) {
+	fi, err := w.lstat(path)
+	if err != nil {
+		return false, err
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return false, err
+	}
+	nfi, err := w.c.fs.Lstat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if errors.Is(err, fs.ErrPermission) {
+		return false, &CheckerDeniedError{Path: path, Err: err}
+	} else if err != nil {
+		return false, err
+	}
+	nst, err := statOf(nfi)
+	if err != nil {
+		return false, err
+	}
+	return st.dev != nst.dev || st.ino != nst.ino, nil
+}
+
+// CheckFile checks whether a user identified by its uid and group ids has the permissions to access an open file.
 //
-// - username is the *nix username of the user
+// Unlike Check, it intentionally skips the path walk: the permissions of the ancestor directories
+// are not checked, since the file was already reached when it was opened. Only the permission bits
+// of the file itself, as returned by f.Stat (fstat(2)), are checked; ACLs and the other file
+// attributes read by path are not consulted.
 //
-// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+// - f is the open file
 //
-// - path is the path of the file/folder
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
 //
 // - returns a PermissionError if the user does not have access the requested access to the file
 //
-// - returns a non-nil error if the user does not exist (in which case the returned
-//   error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+// - returns a non-nil error if an underlying error occurs when reading permissions
 //
 // - if the error is nil, the user has the requested access to the file
-func Username(username string, mode os.FileMode, path string) error {
-	u, err := user.Lookup(username)
+func CheckFile(f *os.File, uid int, gids []int, mode os.FileMode) error {
+	return defaultChecker.CheckFile(f, uid, gids, mode)
+}
+
+// CheckFile is like the package-level CheckFile, using the options of the Checker that apply
+// to the user, for example WithPrimaryGroupOnly and WithMaxGroups.
+func (c *Checker) CheckFile(f *os.File, uid int, gids []int, mode os.FileMode) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	fi, err := f.Stat()
 	if err != nil {
 		return err
 	}
-	uid, err := strconv.Atoi(u.Uid)
+	st, err := statOf(fi)
 	if err != nil {
 		return err
 	}
-	return access(u, uid, mode, path)
+	w := c.newWalk(context.Background(), uid, gids)
+	fm := fi.Mode()
+	need := w.override(fm, mode)
+	if w.denies(fm, st, need) {
+		return w.permissionError(f.Name(), f.Name(), fm, st, mode, need)
+	}
+	return nil
 }
 
-func contains(a []int, i int) bool {
-	for _, e := range a {
-		if e == i {
-			return true
-		}
+// CheckResolved checks whether a user identified by its uid and group ids has the permissions to access an already resolved file.
+//
+// It behaves like Check, except that path is not resolved: it is not made absolute nor cleaned,
+// and its symlinks are not followed. Only the permissions of the file and its ancestors are
+// checked, which saves the work of the resolution for callers that already resolved the path.
+//
+// WARNING: path MUST be absolute, clean (no . or .. components, no repeated or trailing
+// separators), and contain no symlinks, for example as returned by filepath.EvalSymlinks
+// on an absolute path. This is NOT verified: if a component of path is a symlink, the
+// permissions of the symlink itself are checked rather than those of its target, and the
+// result is wrong, which is unsafe if it is used to grant access.
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the absolute, clean, symlink-free path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func CheckResolved(uid int, gids []int, mode os.FileMode, path string) error {
+	return defaultChecker.CheckResolved(uid, gids, mode, path)
+}
+
+// CheckResolved is like the package-level CheckResolved, using the options of the Checker.
+func (c *Checker) CheckResolved(uid int, gids []int, mode os.FileMode, path string) error {
+	return c.checkResolved(uid, gids, mode, path)
+}
+
+func (c *Checker) checkResolved(uid int, gids []int, mode os.FileMode, path string) error {
+	if path == "" {
+		return ErrEmptyPath
 	}
-	return false
+	return c.newWalk(context.Background(), uid, gids).checkPath(mode, path)
 }
 
-// path is absolute, contains no . or ..
-func checkPath(uid int, gid []int, mode os.FileMode, path string) error {
-	for len(path) > 0 {
-		fi, err := os.Lstat(path)
-		if err != nil {
-			return err
-		}
-		fm := fi.Mode()
-		s := fi.Sys().(*syscall.Stat_t)
-
-		if uid != 0 && fm&mode != mode && (fm&(mode<<6) != mode<<6 || uint32(uid) != s.Uid) && (fm&(mode<<3) != mode<<3 || !contains(gid, int(s.Gid))) {
-			return &PermissionError{
-				File:     path,
-				FileMode: fm,
-				FileUid:  int(s.Uid),
-				FileGid:  int(s.Gid),
-				Uid:      uid,
-				Gid:      gid,
-				WantMode: mode,
-			}
-		}
-		mode = 1 // x
+// SearchOnly checks whether a user has the permissions to traverse to the directory containing a file.
+//
+// It checks that the user has the search (execute) permission on the directory containing path
+// and on all its ancestors, like the kernel requires to access any file of the directory, for
+// example to cd(1) into it. No permission is checked on the file itself, which does not need to
+// exist, and the read permission of the directories is not required.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user cannot search the directory containing the file or one of its ancestors
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can traverse to the directory containing the file
+func SearchOnly(uid int, path string) error {
+	return defaultChecker.SearchOnly(uid, path)
+}
 
-		i := strings.LastIndexFunc(path, func(r rune) bool {
-			return r == os.PathSeparator
-		})
-		if i < 0 { // should never happen
-			return errors.New("absolute path not containing any slash: " + path)
-		}
-		path = path[:i]
+// SearchOnly is like the package-level SearchOnly, using the options of the Checker.
+func (c *Checker) SearchOnly(uid int, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
 	}
-	return nil
+	return c.searchOnly(id.Uid, id.Gids, path)
 }
 
-func access(user *user.User, uid int, mode os.FileMode, path string) error {
-	gs, err := user.GroupIds()
+func (c *Checker) searchOnly(uid int, gids []int, path string) error {
+	path, err := absPath(path)
 	if err != nil {
 		return err
 	}
-	gi := make([]int, len(gs))
-	for i, g := range gs {
-		gi[i], err = strconv.Atoi(g)
-		if err != nil {
+	w := c.newWalk(context.Background(), uid, gids)
+	dir, err := w.resolveDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if c.denyWorldWritable {
+		if err := w.checkAncestor(dir); err != nil {
 			return err
 		}
 	}
+	return w.searchDir(dir)
+}
+
+// Resolve resolves a path as a user identified by its uid, like the checks do.
+//
+// Like filepath.EvalSymlinks, the returned path is absolute, clean, and contains no symlinks,
+// but it is resolved with the options of the Checker, for example its maximum symlink depth,
+// and the user must be able to search every directory traversed on the way, including those
+// traversed through symlinks. No permission is checked on the resolved file itself, which must
+// exist.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - returns the absolute path of the file, with all symlinks resolved
+//
+// - returns a PermissionError if the user cannot search a directory on the path, and an error matching fs.ErrNotExist if the file does not exist
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func Resolve(uid int, path string) (string, error) {
+	return defaultChecker.Resolve(uid, path)
+}
+
+// Resolve is like the package-level Resolve, using the options of the Checker.
+func (c *Checker) Resolve(uid int, path string) (string, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return "", err
+	}
+	return c.resolve(id.Uid, id.Gids, path)
+}
+
+func (c *Checker) resolve(uid int, gids []int, path string) (string, error) {
+	return c.newWalk(context.Background(), uid, gids).resolve(path, true)
+}
+
+// StatFor returns the file that a check of a user on a path evaluates.
+//
+// The path is resolved like Uid does, following symlinks with the options of the Checker, and
+// checking that the user can search the directories on the way, and the FileInfo of the resolved
+// file is returned. Unlike os.Stat, it reports exactly the file that a permission check would
+// evaluate, so that its existence and type can be confirmed cheaply beforehand. No permission
+// is checked on the file itself.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - returns the FileInfo of the resolved file, as returned by Lstat
+//
+// - returns a PermissionError if the user cannot search a directory on the path, and an error matching fs.ErrNotExist if the file does not exist
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func StatFor(uid int, path string) (os.FileInfo, error) {
+	return defaultChecker.StatFor(uid, path)
+}
+
+// StatFor is like the package-level StatFor, using the options of the Checker.
+func (c *Checker) StatFor(uid int, path string) (os.FileInfo, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.statFor(id.Uid, id.Gids, path)
+}
 
-	path, err = filepath.Abs(path)
+func (c *Checker) statFor(uid int, gids []int, path string) (os.FileInfo, error) {
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, true)
 	if err != nil {
+		return nil, err
+	}
+	return w.lstat(dest)
+}
+
+// checkCollect resolves path and checks mode on it, for a walk that collects its denials:
+// it returns an error only if the check could not be completed, and a denied folder hides
+// any later error, as it would for Uid
+func (w *walk) checkCollect(mode os.FileMode, path string) error {
+	if err := checkMode(mode); err != nil {
 		return err
 	}
+	dest, err := w.resolve(path, true)
+	// denials made during the resolution did not know the resolved path yet
+	for _, pe := range w.denials {
+		pe.ResolvedPath = dest
+	}
+	if err == nil {
+		err = w.checkPath(mode, dest)
+	}
+	if len(w.denials) > 0 {
+		return nil
+	}
+	return err
+}
+
+// absPath returns the absolute, clean form of path; unlike filepath.Abs, it is guaranteed
+// not to depend on the working directory (nor to call os.Getwd) if path is already absolute,
+// and it rejects an empty path
+func absPath(path string) (string, error) {
+	if path == "" {
+		return "", ErrEmptyPath
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	return filepath.Abs(path)
+}
+
+// resolveDir is like resolve, but also checks that the resolved path is a directory
+func (w *walk) resolveDir(path string) (string, error) {
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return "", err
+	}
+	fi, err := w.lstat(dest)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", &NotDirError{Path: dest}
+	}
+	return dest, nil
+}
+
+// resolve returns the absolute path of path with all symlinks resolved, checking
+// that the user can search all the directories traversed
+//
+// if follow is false and the final component of path is a symlink, it is not resolved
+func (w *walk) resolve(path string, follow bool) (string, error) {
+	// like the kernel, a trailing separator requires the final component to be a directory,
+	// and follows it if it is a symlink
+	wantDir := len(path) > 0 && os.IsPathSeparator(path[len(path)-1])
+	if wantDir {
+		follow = true
+	}
+	path, err := absPath(path)
+	if err != nil {
+		return "", err
+	}
+	requested := path
 
 	// some code adapted from filepath.walkSymlinks
 
@@ -173,8 +1946,41 @@ func access(user *user.User, uid int, mode os.FileMode, path string) error {
 	}
 	vol := path[:volLen]
 	dest := vol
-	linksWalked := 0
+	w.linksWalked, w.components = 0, 0
+	// directory containing the requested file, and number of symlinks from inside it to outside
+	// it, see WithMaxSymlinkEscapes
+	tree := filepath.Dir(path)
+	escapes := 0
+
+	// if the user cannot search a directory, the denial is recorded and the resolution continues
+	// with the privileges of the process, without checking the permissions of the user, to report
+	// the fully resolved path in the PermissionError
+	var denied *PermissionError
+	fail := func(err error) (string, error) {
+		if denied != nil {
+			return "", denied
+		}
+		return "", err
+	}
+	// whether dest has entered the root of the walk, see confine
+	entered := false
+	// device of the root directory, see WithStayOnDevice
+	var rootDev uint64
+	if w.c.stayOnDevice {
+		fi, err := w.lstat(vol)
+		if err != nil {
+			return "", err
+		}
+		st, err := statOf(fi)
+		if err != nil {
+			return "", err
+		}
+		rootDev = st.dev
+	}
 	for start, end := volLen, volLen; start < len(path); start = end {
+		if err := w.confine(&entered, dest); err != nil {
+			return fail(err)
+		}
 		for start < len(path) && os.IsPathSeparator(path[start]) {
 			start++
 		}
@@ -201,15 +2007,11 @@ func access(user *user.User, uid int, mode os.FileMode, path string) error {
 					break
 				}
 			}
-			if r < volLen || dest[r+1:] == ".." {
-				// Either path has no slashes
-				// (it's empty or just "C:")
-				// or it ends in a ".." we had to keep.
-				// Either way, keep this "..".
-				if len(dest) > volLen {
-					dest += pathSeparator
-				}
-				dest += ".."
+			if r < volLen {
+				// dest is the root directory, or a single
+				// component below it. path is absolute, so
+				// there is no ".." to keep: back up to the root.
+				dest = vol
 			} else {
 				// Discard everything since the last slash.
 				dest = dest[:r]
@@ -219,6 +2021,8 @@ func access(user *user.User, uid int, mode os.FileMode, path string) error {
 
 		// Ordinary path component. Add it to result.
 
+		w.components++
+
 		if len(dest) > 0 && !os.IsPathSeparator(dest[len(dest)-1]) {
 			dest += pathSeparator
 		}
@@ -226,43 +2030,97 @@ func access(user *user.User, uid int, mode os.FileMode, path string) error {
 		l := len(dest)
 
 		dest += path[start:end]
+		if err := w.confine(&entered, dest); err != nil {
+			return fail(err)
+		}
 
 		// Check perms on symlink.
 
-		if err := checkPath(uid, gi, 1, dest[:l]); err != nil {
-			return err
+		dir := dest[:l]
+		if l > volLen {
+			// strip the trailing separator
+			dir = dest[:l-1]
+		}
+		if w.c.denyWorldWritable {
+			if err := w.checkAncestor(dir); err != nil {
+				return fail(err)
+			}
+		}
+		if denied == nil {
+			if err := w.searchDir(dir); err != nil {
+				if !errors.As(err, &denied) {
+					return "", err
+				}
+				denied.ResolvedPath = ""
+			}
 		}
 
 		// Resolve symlink.
 
-		fi, err := os.Lstat(dest)
+		fi, err := w.lstat(dest)
 		if err != nil {
-			return err
+			return fail(err)
+		}
+		if w.c.stayOnDevice {
+			st, err := statOf(fi)
+			if err != nil {
+				return fail(err)
+			}
+			if st.dev != rootDev {
+				return fail(&CrossDeviceError{Path: dest, Dev: st.dev, RootDev: rootDev})
+			}
 		}
 
-		if fi.Mode()&os.ModeSymlink == 0 {
+		if fi.Mode()&os.ModeSymlink != 0 && w.c.noSymlinks {
+			return fail(&SymlinkError{Path: dest})
+		}
+		if fi.Mode()&os.ModeSymlink == 0 || (!follow && end == len(path)) {
 			if !fi.Mode().IsDir() && end < len(path) {
-				return syscall.ENOTDIR
+				return fail(&NotDirError{Path: dest})
 			}
 			continue
 		}
 
 		// Found symlink.
 
-		linksWalked++
-		if linksWalked > 255 {
-			return errors.New("access: too many links")
+		w.linksWalked++
+		if w.linksWalked > w.c.maxSymlinkDepth {
+			return fail(&TooManyLinksError{Path: requested, Links: w.linksWalked, Limit: w.c.maxSymlinkDepth})
 		}
 
-		link, err := os.Readlink(dest)
+		link, err := w.readlink(dest)
 		if err != nil {
-			return err
+			return fail(err)
+		}
+
+		target := link
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(dest), target)
+		}
+		target = filepath.Clean(target)
+		if w.c.allowedTargets != nil && !w.c.allowedTarget(target) {
+			return fail(&SymlinkTargetError{Path: dest, Link: link, Target: target})
+		}
+		if w.c.maxSymlinkEscapes >= 0 && within(tree, dest) {
+			if !within(tree, target) {
+				escapes++
+				if escapes > w.c.maxSymlinkEscapes {
+					return fail(&SymlinkEscapeError{Path: dest, Link: link, Escapes: escapes})
+				}
+			}
 		}
 
 		path = link + path[end:]
 
 		if len(link) > 0 && os.IsPathSeparator(link[0]) {
 			// Symlink to absolute path.
+			// The walk restarts from the root directory, through the ancestors of the root of
+			// the walk, if any: confine the target instead, then the components of the link as
+			// they are walked again.
+			if err := w.confine(&entered, target); err != nil {
+				return fail(err)
+			}
+			entered = false
 			dest = link[:1]
 			end = 1
 		} else {
@@ -283,10 +2141,26 @@ func access(user *user.User, uid int, mode os.FileMode, path string) error {
 		}
 	}
 
-	// all symlinks resolved, check access on final path
-	if err := checkPath(uid, gi, mode, dest); err != nil {
-		return err
+	if w.root != "" && !within(w.root, dest) {
+		return fail(&OutsideRootError{Path: dest, Root: w.root})
 	}
-
-	return nil
+	if denied != nil {
+		denied.ResolvedPath = dest
+		return "", denied
+	}
+	if wantDir {
+		fi, err := w.lstat(dest)
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", &NotDirError{Path: dest}
+		}
+	}
+	if w.c.rejectSpecialFS {
+		if err := w.checkSpecialFS(dest); err != nil {
+			return "", err
+		}
+	}
+	return dest, nil
 }
@@ -1,9 +1,27 @@
+//go:build !windows
+// +build !windows
+
 package access
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
 )
 
 func ExampleUsername() {
@@ -34,3 +52,2915 @@ func ExampleUsername() {
 	// Output:
 	// current user can access this executable!
 }
+
+func ExampleCurrent() {
+	// check if the current user has access to the current program
+
+	file, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+
+	err = Current(Read, file)
+	if err != nil {
+		if e, ok := err.(*PermissionError); !ok {
+			panic(err)
+		} else {
+			fmt.Printf("current user does not have access to this executable: %s\n", e)
+			return
+		}
+	} else {
+		fmt.Println("current user can access this executable!")
+	}
+
+	// Output:
+	// current user can access this executable!
+}
+
+func TestRootExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Check(0, []int{0}, Read|Write, file); err != nil {
+		t.Errorf("root read/write on mode 0644: %v", err)
+	}
+	if err := Check(0, []int{0}, Execute, file); err == nil {
+		t.Errorf("root execute on mode 0644: got nil error")
+	} else if _, ok := err.(*PermissionError); !ok {
+		t.Errorf("root execute on mode 0644: got %v, want PermissionError", err)
+	}
+
+	if err := os.Chmod(file, 0645); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(0, []int{0}, Execute, file); err != nil {
+		t.Errorf("root execute on mode 0645: %v", err)
+	}
+
+	if err := Check(0, []int{0}, Execute, filepath.Dir(file)); err != nil {
+		t.Errorf("root execute on directory: %v", err)
+	}
+}
+
+func TestCan(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+	denied := c.Check(1001, []int{1001}, Read, "/home/alice/file")
+	tests := []struct {
+		name string
+		err  error
+		can  bool
+		want error
+	}{
+		{"allowed", c.Check(1000, []int{1000}, Read, "/home/alice/file"), true, nil},
+		{"denied", denied, false, nil},
+		{"wrapped denial", fmt.Errorf("checking: %w", denied), false, nil},
+		{"too many links", New(WithFileSystem(testFS), WithMaxSymlinkDepth(0)).Check(1000, []int{1000}, Read, "/srv/data"), false, ErrTooManyLinks},
+		{"missing", c.Check(1000, []int{1000}, Read, "/srv/hidden"), false, fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		if can, err := can(tt.err); can != tt.can || !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, can, err, tt.can, tt.want)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if can, err := CanUid(0, Read, file); !can || err != nil {
+		t.Errorf("CanUid allowed: got %v, %v, want true, nil", can, err)
+	}
+	if can, err := CanUid(0, Execute, file); can || err != nil {
+		t.Errorf("CanUid denied: got %v, %v, want false, nil", can, err)
+	}
+	var ue user.UnknownUserIdError
+	if can, err := CanUid(-2, Read, file); can || !errors.As(err, &ue) {
+		t.Errorf("CanUid unknown user: got %v, %v, want false, UnknownUserIdError", can, err)
+	}
+	if can, err := CanUsername("root", Read, file); !can || err != nil {
+		t.Errorf("CanUsername allowed: got %v, %v, want true, nil", can, err)
+	}
+	if can, err := CanUsername("root", Execute, file); can || err != nil {
+		t.Errorf("CanUsername denied: got %v, %v, want false, nil", can, err)
+	}
+	var une user.UnknownUserError
+	if can, err := CanUsername("access-unknown-user", Read, file); can || !errors.As(err, &une) {
+		t.Errorf("CanUsername unknown user: got %v, %v, want false, UnknownUserError", can, err)
+	}
+}
+
+func TestCheckWithGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file of a "deploy" group, which alice is not a member of in the user database
+	const deploy = 4242
+	file := filepath.Join(dir, "release")
+	if err := ioutil.WriteFile(file, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(file, 0, deploy); err != nil {
+		t.Skipf("cannot change file owner: %v", err)
+	}
+	if err := os.Chmod(file, 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckWithGroups(1000, []int{1000}, Write, file); !errors.As(err, new(*PermissionError)) {
+		t.Errorf("login groups: got %v, want PermissionError", err)
+	}
+	if err := CheckWithGroups(1000, []int{1000, deploy}, Write, file); err != nil {
+		t.Errorf("deploy group active: %v", err)
+	}
+	if err := CheckWithGroups(1000, []int{deploy, 1000}, Write, file); err != nil {
+		t.Errorf("deploy group as primary group: %v", err)
+	}
+}
+
+func TestModeShorthands(t *testing.T) {
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("cannot look up user nobody: %v", err)
+	}
+	uid, err := parseId("uid", u.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() == uid {
+		t.Skip("running as user nobody")
+	}
+
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	shorthands := []struct {
+		name string
+		mode os.FileMode
+		fn   func() error
+	}{
+		{"ReadableUid", Read, func() error { return ReadableUid(uid, file) }},
+		{"WritableUid", Write, func() error { return WritableUid(uid, file) }},
+		{"ExecutableUid", Execute, func() error { return ExecutableUid(uid, file) }},
+		{"ReadableUsername", Read, func() error { return ReadableUsername(u.Username, file) }},
+		{"WritableUsername", Write, func() error { return WritableUsername(u.Username, file) }},
+		{"ExecutableUsername", Execute, func() error { return ExecutableUsername(u.Username, file) }},
+	}
+	// the file only grants each mode in turn to the other users
+	for _, mode := range []os.FileMode{Read, Write, Execute} {
+		if err := os.Chmod(file, 0600|mode); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range shorthands {
+			err := s.fn()
+			if s.mode == mode && err != nil {
+				t.Errorf("%s on mode %v: %v", s.name, 0600|mode, err)
+			}
+			var pe *PermissionError
+			if s.mode != mode && !errors.As(err, &pe) {
+				t.Errorf("%s on mode %v: got %v, want PermissionError", s.name, 0600|mode, err)
+			} else if s.mode != mode && pe.WantMode != s.mode {
+				t.Errorf("%s on mode %v: got wanted mode %v, want %v", s.name, 0600|mode, pe.WantMode, s.mode)
+			}
+		}
+	}
+}
+
+func TestCanDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(file, 1000, 1000); err != nil {
+		t.Skipf("cannot change file owner: %v", err)
+	}
+
+	if err := defaultChecker.canDelete(1001, []int{1001}, file); err != nil {
+		t.Errorf("delete in non-sticky directory: %v", err)
+	}
+
+	if err := os.Chmod(dir, 0777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if err := defaultChecker.canDelete(1000, []int{1000}, file); err != nil {
+		t.Errorf("delete own file in sticky directory: %v", err)
+	}
+	if err := defaultChecker.canDelete(1001, []int{1001}, file); err == nil {
+		t.Errorf("delete other file in sticky directory: got nil error")
+	} else if _, ok := err.(*StickyError); !ok {
+		t.Errorf("delete other file in sticky directory: got %v, want StickyError", err)
+	}
+
+	// the sticky directory rule is checked for the real uid
+	alice := Identity{Uid: 1000, Gids: []int{1000}}
+	bob := Identity{Uid: 1001, Gids: []int{1001}}
+	if err := CanDeleteUser(alice, bob, file); err != nil {
+		t.Errorf("delete file of real uid in sticky directory: %v", err)
+	}
+	var se *StickyError
+	if err := CanDeleteUser(bob, alice, file); !errors.As(err, &se) {
+		t.Errorf("delete file of effective uid in sticky directory: got %v, want StickyError", err)
+	} else if se.Uid != bob.Uid || se.FileUid != alice.Uid {
+		t.Errorf("delete file of effective uid in sticky directory: got StickyError for uid %d on file of uid %d, want %d on file of uid %d", se.Uid, se.FileUid, bob.Uid, alice.Uid)
+	}
+
+	if err := os.Chmod(dir, 0755|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if err := defaultChecker.canDelete(1000, []int{1000}, file); err == nil {
+		t.Errorf("delete in non-writable directory: got nil error")
+	} else if _, ok := err.(*PermissionError); !ok {
+		t.Errorf("delete in non-writable directory: got %v, want PermissionError", err)
+	}
+}
+
+func TestCanRename(t *testing.T) {
+	fsys := memFS{
+		"/":             {mode: os.ModeDir | 0755},
+		"/src":          {mode: os.ModeDir | 0777},
+		"/src/file":     {mode: 0644, uid: 1000, gid: 1000},
+		"/src/dir":      {mode: os.ModeDir | 0555, uid: 1000, gid: 1000},
+		"/tmp":          {mode: os.ModeDir | os.ModeSticky | 0777},
+		"/tmp/alice":    {mode: 0644, uid: 1000, gid: 1000},
+		"/tmp/bob":      {mode: 0644, uid: 1001, gid: 1001},
+		"/ro":           {mode: os.ModeDir | 0755},
+		"/dst":          {mode: os.ModeDir | 0777},
+		"/dst/existing": {mode: 0644, uid: 1001, gid: 1001},
+	}
+	c := New(WithFileSystem(fsys))
+
+	tests := []struct {
+		name        string
+		uid         int
+		oldpath     string
+		newpath     string
+		err         error
+		destination bool
+	}{
+		{"same directory", 1001, "/src/file", "/src/other", nil, false},
+		{"other directory", 1001, "/src/file", "/dst/file", nil, false},
+		{"replace in non-sticky directory", 1000, "/src/file", "/dst/existing", nil, false},
+		{"own file out of sticky directory", 1000, "/tmp/alice", "/dst/file", nil, false},
+		{"other file out of sticky directory", 1001, "/tmp/alice", "/dst/file", &StickyError{}, false},
+		{"replace own file in sticky directory", 1001, "/src/file", "/tmp/bob", nil, false},
+		{"replace other file in sticky directory", 1001, "/src/file", "/tmp/alice", &StickyError{}, true},
+		{"out of read-only directory", 1000, "/ro/file", "/dst/file", &PermissionError{}, false},
+		{"into read-only directory", 1000, "/src/file", "/ro/file", &PermissionError{}, true},
+		{"non-writable directory within its directory", 1000, "/src/dir", "/src/moved", nil, false},
+		{"non-writable directory to another directory", 1000, "/src/dir", "/dst/dir", &PermissionError{}, false},
+		{"root", 0, "/tmp/alice", "/ro/file", nil, false},
+	}
+	for _, tt := range tests {
+		err := c.canRename(tt.uid, []int{tt.uid}, tt.oldpath, tt.newpath)
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var re *RenameError
+		if !errors.As(err, &re) {
+			t.Errorf("%s: got %v, want RenameError", tt.name, err)
+			continue
+		}
+		if re.Destination != tt.destination {
+			t.Errorf("%s: got destination %v, want %v", tt.name, re.Destination, tt.destination)
+		}
+		if reflect.TypeOf(re.Err) != reflect.TypeOf(tt.err) {
+			t.Errorf("%s: got %v, want %T", tt.name, re.Err, tt.err)
+		}
+	}
+
+	if err := c.canRename(1000, []int{1000}, "/", "/dst/root"); err == nil {
+		t.Errorf("root directory: got nil error")
+	}
+}
+
+func TestInvalidMode(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	for _, mode := range []os.FileMode{0400, 0644, Read | 0200, os.ModeDir | Read} {
+		if err := c.check(context.Background(), 1000, []int{1000}, mode, "/home/alice/file", true); !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("check mode %v: got %v, want ErrInvalidMode", mode, err)
+		}
+		// the mode is validated before any denial is collected
+		if _, err := c.trace(1001, []int{1001}, mode, "/home/alice/file"); !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("trace mode %v: got %v, want ErrInvalidMode", mode, err)
+		}
+		if _, err := c.evaluate(1000, []int{1000}, mode, "/home/alice/file"); !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("evaluate mode %v: got %v, want ErrInvalidMode", mode, err)
+		}
+		if err := c.checkResolved(1000, []int{1000}, mode, "/home/alice/file"); !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("check resolved mode %v: got %v, want ErrInvalidMode", mode, err)
+		}
+	}
+	for _, mode := range []os.FileMode{0, Read, Read | Write} {
+		if err := c.check(context.Background(), 1000, []int{1000}, mode, "/home/alice/file", true); err != nil {
+			t.Errorf("check mode %v: %v", mode, err)
+		}
+	}
+}
+
+func TestPermissionErrorString(t *testing.T) {
+	err := &PermissionError{
+		File:        "/srv/data",
+		FileMode:    os.ModeDir | 0750,
+		FileUid:     0,
+		FileGid:     27,
+		Uid:         1000,
+		Gid:         []int{1000, 4, 27},
+		WantMode:    Read,
+		MissingMode: Read,
+	}
+	want := "unsufficient permissions of user (uid 1000, user groups [1000 4 27]) for file [/srv/data] (uid 0, gid 27): want mode 4, file has mode 20000000750, missing mode 4"
+	if got := err.Error(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPermissionErrorJSON(t *testing.T) {
+	err := &PermissionError{
+		File:         "/srv",
+		FileMode:     os.ModeDir | os.ModeSetgid | 0750,
+		FileUid:      0,
+		FileGid:      27,
+		Uid:          1000,
+		Gid:          []int{1000, 4},
+		WantMode:     Execute,
+		MissingMode:  Execute,
+		ResolvedPath: "/srv/data",
+	}
+	b, jerr := json.Marshal(err)
+	if jerr != nil {
+		t.Fatal(jerr)
+	}
+	want := `{"file":"/srv","fileMode":"042750","fileUid":0,"fileGid":27,"uid":1000,"gid":[1000,4],"wantMode":"0001","missingMode":"0001","resolvedPath":"/srv/data"}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+	var got PermissionError
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, err) {
+		t.Errorf("round trip: got %+v, want %+v", got, err)
+	}
+
+	for _, fm := range []os.FileMode{0644, os.ModeSymlink | 0777, os.ModeNamedPipe | 0600, os.ModeSocket | 0755, os.ModeDevice | os.ModeCharDevice | 0666, os.ModeDevice | 0660, os.ModeSetuid | os.ModeSticky | 0755} {
+		if got := fileModeOf(unixMode(fm)); got != fm {
+			t.Errorf("round trip of mode %v: got %v", fm, got)
+		}
+	}
+
+	if b, _ := json.Marshal(&PermissionError{}); !strings.Contains(string(b), `"gid":[]`) {
+		t.Errorf("nil gids: got %s, want empty array", b)
+	}
+	if err := json.Unmarshal([]byte(`{"fileMode":"0789"}`), &got); err == nil {
+		t.Errorf("malformed mode: got nil error")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop", filepath.Join(dir, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	var nde *NotDirError
+	if err := Check(0, []int{0}, Read, filepath.Join(file, "child")); !errors.Is(err, syscall.ENOTDIR) || !errors.As(err, &nde) {
+		t.Errorf("file with child: got %v, want NotDirError", err)
+	} else if nde.Path != file {
+		t.Errorf("file with child: got NotDirError on %s, want %s", nde.Path, file)
+	}
+	if err := CanDelete(0, filepath.Join(file, "child")); !errors.As(err, &nde) {
+		t.Errorf("delete file with child: got %v, want NotDirError", err)
+	}
+	if _, err := CanCreate(0, []int{0}, filepath.Join(file, "child")); !errors.As(err, &nde) {
+		t.Errorf("create file with child: got %v, want NotDirError", err)
+	}
+	if err := Check(0, []int{0}, Read, filepath.Join(dir, "loop")); !errors.Is(err, ErrTooManyLinks) {
+		t.Errorf("symlink loop: got %v, want ErrTooManyLinks", err)
+	}
+	if err := Check(0, []int{0}, Read, filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want ErrNotExist", err)
+	}
+	var pe *PermissionError
+	if err := Check(1000, []int{1000}, Read, file); !errors.As(err, &pe) {
+		t.Errorf("unreadable file: got %v, want PermissionError", err)
+	} else if !errors.Is(err, ErrPermission) || !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("unreadable file: got %v, want ErrPermission and fs.ErrPermission", err)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unreadable file: got %v matching fs.ErrNotExist", err)
+	}
+	if err := Check(0, []int{0}, Read, filepath.Join(dir, "missing")); errors.Is(err, ErrPermission) {
+		t.Errorf("missing file: got %v matching ErrPermission", err)
+	}
+}
+
+func TestNoFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("file", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := defaultChecker.check(context.Background(), 1000, []int{1000}, Read, link, true); err == nil {
+		t.Errorf("read symlink target: got nil error")
+	}
+	if err := defaultChecker.check(context.Background(), 1000, []int{1000}, Read, link, false); err != nil {
+		t.Errorf("read symlink itself: %v", err)
+	}
+
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("cannot look up user nobody: %v", err)
+	}
+	uid, err := parseId("uid", u.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid == os.Getuid() {
+		t.Skip("running as user nobody")
+	}
+	dangling := filepath.Join(dir, "dangling")
+	if err := os.Symlink("missing", dangling); err != nil {
+		t.Fatal(err)
+	}
+	var pe *PermissionError
+	if err := Uid(uid, Read, link); !errors.As(err, &pe) || pe.File != file {
+		t.Errorf("Uid on symlink to denied file: got %v, want PermissionError on %s", err, file)
+	}
+	if err := UidNoFollow(uid, Read, link); err != nil {
+		t.Errorf("UidNoFollow on symlink to denied file: %v", err)
+	}
+	if err := Uid(uid, Read, dangling); !os.IsNotExist(err) {
+		t.Errorf("Uid on dangling symlink: got %v, want not exist", err)
+	}
+	if err := UidNoFollow(uid, Read, dangling); err != nil {
+		t.Errorf("UidNoFollow on dangling symlink: %v", err)
+	}
+	// only the final symlink is not followed
+	if err := UidNoFollow(uid, Read, filepath.Join(dir, "dangling", "file")); !os.IsNotExist(err) {
+		t.Errorf("UidNoFollow through dangling symlink: got %v, want not exist", err)
+	}
+}
+
+func TestMaxSymlinkDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("link1", filepath.Join(dir, "link2")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link2")
+
+	if err := New(WithMaxSymlinkDepth(2)).Check(0, []int{0}, Read, link); err != nil {
+		t.Errorf("depth 2: %v", err)
+	}
+	var te *TooManyLinksError
+	if err := New(WithMaxSymlinkDepth(1)).Check(0, []int{0}, Read, link); !errors.As(err, &te) || !errors.Is(err, ErrTooManyLinks) {
+		t.Errorf("depth 1: got %v, want TooManyLinksError", err)
+	} else if te.Links != 2 || te.Limit != 1 || te.Path != link {
+		t.Errorf("depth 1: got %d links walked for %s with limit %d, want 2 for %s with limit 1", te.Links, te.Path, te.Limit, link)
+	}
+}
+
+func TestMissingMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(file, 1000, 1000); err != nil {
+		t.Skipf("cannot change file owner: %v", err)
+	}
+
+	tests := []struct {
+		uid     int
+		gids    []int
+		missing os.FileMode
+	}{
+		{1000, []int{1000}, Execute},
+		{1001, []int{1000}, Write | Execute},
+		{1001, []int{1001}, Read | Write | Execute},
+	}
+	for _, tt := range tests {
+		var pe *PermissionError
+		if err := Check(tt.uid, tt.gids, Read|Write|Execute, file); !errors.As(err, &pe) {
+			t.Errorf("uid %d, gids %v: got %v, want PermissionError", tt.uid, tt.gids, err)
+		} else if pe.MissingMode != tt.missing {
+			t.Errorf("uid %d, gids %v: got missing mode %o, want %o", tt.uid, tt.gids, pe.MissingMode, tt.missing)
+		}
+	}
+}
+
+func TestEvaluateTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the denials are reported on resolved paths
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	private := filepath.Join(dir, "private")
+	if err := os.Mkdir(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{filepath.Join(dir, "public"), filepath.Join(private, "file")} {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	errs, err := defaultChecker.evaluateTree(context.Background(), 65533, []int{65533}, Read, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 4 {
+		t.Errorf("got %d entries, want 4", len(errs))
+	}
+	for _, path := range []string{dir, filepath.Join(dir, "public")} {
+		if err, ok := errs[path]; !ok || err != nil {
+			t.Errorf("%s: got %v (present: %v), want nil", path, err, ok)
+		}
+	}
+	for _, path := range []string{private, filepath.Join(private, "file")} {
+		var pe *PermissionError
+		if err := errs[path]; !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", path, err)
+		} else if pe.File != private {
+			t.Errorf("%s: got denial on %q, want %q", path, pe.File, private)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := defaultChecker.evaluateTree(ctx, 65533, []int{65533}, Read, dir); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: got %v, want context.Canceled", err)
+	}
+}
+
+func TestMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0751); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(file, 1000, 1000); err != nil {
+		t.Skipf("cannot change file owner: %v", err)
+	}
+
+	tests := []struct {
+		dirMode os.FileMode
+		uid     int
+		gids    []int
+		mode    os.FileMode
+	}{
+		{0755, 1000, []int{1000}, Read | Write | Execute},
+		{0755, 1001, []int{1000}, Read | Execute},
+		{0755, 1001, []int{1001}, Execute},
+		{0700, 1000, []int{1000}, 0},
+	}
+	for _, tt := range tests {
+		if err := os.Chmod(dir, tt.dirMode); err != nil {
+			t.Fatal(err)
+		}
+		mode, err := defaultChecker.mode(tt.uid, tt.gids, file)
+		if err != nil {
+			t.Errorf("uid %d, gids %v, dir mode %o: %v", tt.uid, tt.gids, tt.dirMode, err)
+		} else if mode != tt.mode {
+			t.Errorf("uid %d, gids %v, dir mode %o: got mode %o, want %o", tt.uid, tt.gids, tt.dirMode, mode, tt.mode)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	file, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UidContext(ctx, os.Getuid(), Read, file); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+type memFile struct {
+	mode  os.FileMode
+	uid   int
+	gid   int
+	dev   uint64
+	ino   uint64
+	nlink uint64
+	link  string
+}
+
+// memFS is an in-memory FileSystem, keyed by clean absolute paths
+type memFS map[string]memFile
+
+func (m memFS) Lstat(name string) (os.FileInfo, error) {
+	f, ok := m[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	return memFileInfo{name: filepath.Base(name), f: f}, nil
+}
+
+func (m memFS) Readlink(name string) (string, error) {
+	f, ok := m[filepath.Clean(name)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	if f.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return f.link, nil
+}
+
+type memFileInfo struct {
+	name string
+	f    memFile
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return 0 }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.f.mode }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.f.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{} {
+	st := &syscall.Stat_t{Uid: uint32(fi.f.uid), Gid: uint32(fi.f.gid)}
+	setInt(&st.Dev, fi.f.dev)
+	setInt(&st.Ino, fi.f.ino)
+	setInt(&st.Nlink, fi.f.nlink)
+	return st
+}
+
+// setInt sets a field of a syscall.Stat_t, whose integer type depends on the platform
+func setInt[T ~int16 | ~uint16 | ~int32 | ~int64 | ~uint32 | ~uint64](p *T, v uint64) {
+	*p = T(v)
+}
+
+var testFS = memFS{
+	"/":                {mode: os.ModeDir | 0755},
+	"/home":            {mode: os.ModeDir | 0755},
+	"/home/alice":      {mode: os.ModeDir | 0700, uid: 1000, gid: 1000},
+	"/home/alice/file": {mode: 0644, uid: 1000, gid: 1000},
+	"/home/alice/link": {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "file"},
+	"/srv":             {mode: os.ModeDir | 0755},
+	"/srv/data":        {mode: os.ModeSymlink | 0777, link: "/home/alice/file"},
+	"/srv/rel":         {mode: os.ModeSymlink | 0777, link: "../home/alice"},
+	"/srv/shared":      {mode: os.ModeDir | 0750, gid: 100},
+	"/srv/shared/doc":  {mode: 0640, gid: 100},
+	"/srv/setgid":      {mode: os.ModeDir | os.ModeSetgid | 0777, gid: 100},
+	"/srv/dangling":    {mode: os.ModeSymlink | 0777, link: "missing"},
+	"/srv/hidden":      {mode: os.ModeSymlink | 0777, link: "/home/alice/missing"},
+	"/tmp":             {mode: os.ModeDir | os.ModeSticky | 0777},
+	"/tmp/alice":       {mode: 0600, uid: 1000, gid: 1000},
+}
+
+func TestCheckFS(t *testing.T) {
+	alice := []int{1000}
+	bob := []int{1001}
+	bobShared := []int{1001, 100}
+
+	tests := []struct {
+		uid      int
+		gids     []int
+		mode     os.FileMode
+		path     string
+		wantFile string // file of the PermissionError, if any
+		wantErr  error  // other error, if any
+	}{
+		{1001, bob, Read, "/srv/rel/file", "/home/alice", nil},
+		{1001, bob, Read, "/srv/rel/missing", "/home/alice", nil},
+		{1000, alice, Read | Write, "/home/alice/file", "", nil},
+		{1000, alice, Read, "/home/alice/link", "", nil},
+		{1001, bob, Read, "/home/alice/file", "/home/alice", nil},
+		{1001, bob, Read, "/srv/data", "/home/alice", nil},
+		{1000, alice, Read, "/srv/data", "", nil},
+		{1000, alice, Write, "/srv/rel/file", "", nil},
+		{1000, alice, Write, "/srv/rel/../rel/link", "", nil},
+		{1001, bobShared, Read, "/srv/shared/doc", "", nil},
+		{1001, bobShared, Write, "/srv/shared/doc", "/srv/shared/doc", nil},
+		{1001, bob, Read, "/srv/shared/doc", "/srv/shared", nil},
+		{1000, alice, Read, "/home/alice/file/child", "", syscall.ENOTDIR},
+		{1000, alice, Read, "/home/alice/missing", "", os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		err := CheckFS(testFS, tt.uid, tt.gids, tt.mode, tt.path)
+		var pe *PermissionError
+		switch {
+		case tt.wantFile != "":
+			if !errors.As(err, &pe) {
+				t.Errorf("uid %d, mode %o, path %s: got %v, want PermissionError", tt.uid, tt.mode, tt.path, err)
+			} else if pe.File != tt.wantFile {
+				t.Errorf("uid %d, mode %o, path %s: got PermissionError on %s, want %s", tt.uid, tt.mode, tt.path, pe.File, tt.wantFile)
+			}
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("uid %d, mode %o, path %s: got %v, want %v", tt.uid, tt.mode, tt.path, err, tt.wantErr)
+			}
+		default:
+			if err != nil {
+				t.Errorf("uid %d, mode %o, path %s: %v", tt.uid, tt.mode, tt.path, err)
+			}
+		}
+	}
+}
+
+func TestCanCreate(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	tests := []struct {
+		uid      int
+		gids     []int
+		path     string
+		wantFile string // file of the PermissionError, if any
+		wantGid  int
+	}{
+		{1000, []int{1000}, "/home/alice/new", "", 1000},
+		{1001, []int{1001}, "/home/alice/new", "/home/alice", 0},
+		{1001, []int{1001, 100}, "/srv/shared/new", "/srv/shared", 0},
+		{1001, []int{1001}, "/srv/setgid/new", "", 100},
+		{1000, []int{1000}, "/srv/rel/new", "", 1000},
+	}
+	for _, tt := range tests {
+		cr, err := c.canCreate(tt.uid, tt.gids, tt.path)
+		var pe *PermissionError
+		if tt.wantFile != "" {
+			if !errors.As(err, &pe) {
+				t.Errorf("uid %d, path %s: got %v, want PermissionError", tt.uid, tt.path, err)
+			} else if pe.File != tt.wantFile {
+				t.Errorf("uid %d, path %s: got PermissionError on %s, want %s", tt.uid, tt.path, pe.File, tt.wantFile)
+			}
+		} else if err != nil {
+			t.Errorf("uid %d, path %s: %v", tt.uid, tt.path, err)
+		} else if cr.Gid != tt.wantGid {
+			t.Errorf("uid %d, path %s: got gid %d, want %d", tt.uid, tt.path, cr.Gid, tt.wantGid)
+		}
+	}
+}
+
+func TestEffectiveCreateMode(t *testing.T) {
+	tests := []struct {
+		base  os.FileMode
+		umask os.FileMode
+		want  os.FileMode
+	}{
+		{0666, 0, 0666},
+		{0666, 0022, 0644},
+		{0777, 0022, 0755},
+		{0666, 0077, 0600},
+		{0644, 0002, 0644},
+		{0777, 0777, 0},
+		// only the permission bits of umask are used
+		{0666, os.ModeSetgid | os.ModeSticky | 0027, 0640},
+		{0777 | os.ModeSetgid, os.ModeSetgid | 0022, 0755 | os.ModeSetgid},
+	}
+	for _, tt := range tests {
+		if got := EffectiveCreateMode(tt.base, tt.umask); got != tt.want {
+			t.Errorf("base %v, umask %v: got %v, want %v", tt.base, tt.umask, got, tt.want)
+		}
+	}
+
+	// the predicted mode of CanCreate is 0666 with the umask applied
+	for _, umask := range []os.FileMode{0, 0022, 0077, os.ModeSticky | 0027} {
+		c := New(WithFileSystem(testFS), WithUmask(umask))
+		cr, err := c.CanCreate(1000, []int{1000}, "/home/alice/new")
+		if err != nil {
+			t.Errorf("umask %v: %v", umask, err)
+		} else if want := os.FileMode(0666) &^ (umask & os.ModePerm); cr.Mode != want {
+			t.Errorf("umask %v: got mode %v, want %v", umask, cr.Mode, want)
+		}
+	}
+}
+
+// countFS counts the Lstat calls of a FileSystem, by path
+type countFS struct {
+	FileSystem
+	lstats map[string]int
+}
+
+func (c countFS) Lstat(name string) (os.FileInfo, error) {
+	c.lstats[name]++
+	return c.FileSystem.Lstat(name)
+}
+
+func TestCheckerConcurrent(t *testing.T) {
+	c := New(WithFileSystem(testFS), WithMaxSymlinkEscapes(1))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			uid := 1000 + i%2
+			err := c.Check(uid, []int{uid}, Read, "/srv/data")
+			if (uid == 1000) != (err == nil) {
+				errs <- fmt.Errorf("uid %d: got %v", uid, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLstatOnce(t *testing.T) {
+	for _, path := range []string{"/home/alice/file", "/srv/data", "/srv/rel/../rel/link", "/srv/shared/doc"} {
+		fsys := countFS{FileSystem: testFS, lstats: make(map[string]int)}
+		if err := CheckFS(fsys, 0, []int{0}, Read, path); err != nil {
+			t.Errorf("path %s: %v", path, err)
+		}
+		for name, n := range fsys.lstats {
+			if n > 1 {
+				t.Errorf("path %s: %s stat'ed %d times", path, name, n)
+			}
+		}
+	}
+}
+
+func TestTrustedRoot(t *testing.T) {
+	c := New(WithFileSystem(testFS), WithTrustedRoot("/home/alice/"))
+
+	for _, path := range []string{"/home/alice/file", "/srv/data"} {
+		if err := c.Check(1001, []int{1001}, Read, path); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+	var pe *PermissionError
+	for _, tt := range []struct {
+		mode os.FileMode
+		path string
+		file string
+	}{
+		{Write, "/home/alice/file", "/home/alice/file"},
+		{Read, "/home/alice", "/home/alice"},
+		{Read, "/srv/shared/doc", "/srv/shared"},
+		// the requested permission is checked on the trusted root itself
+		{Execute, "/home/alice", "/home/alice"},
+		{Execute, "/home/alice/", "/home/alice"},
+	} {
+		if err := c.Check(1001, []int{1001}, tt.mode, tt.path); !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", tt.path, err)
+		} else if pe.File != tt.file {
+			t.Errorf("%s: got denial on %q, want %q", tt.path, pe.File, tt.file)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("relative trusted root: got no panic")
+		}
+	}()
+	New(WithTrustedRoot("home/alice"))
+}
+
+// rawFS is a FileSystem whose FileInfo do not return a *syscall.Stat_t
+type rawFS struct {
+	memFS
+}
+
+func (r rawFS) Lstat(name string) (os.FileInfo, error) {
+	fi, err := r.memFS.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return rawFileInfo{fi}, nil
+}
+
+type rawFileInfo struct {
+	os.FileInfo
+}
+
+func (rawFileInfo) Sys() interface{} {
+	return nil
+}
+
+func TestUnsupportedSys(t *testing.T) {
+	err := CheckFS(rawFS{testFS}, 1000, []int{1000}, Read, "/home/alice/file")
+	if err == nil || !strings.Contains(err.Error(), "unsupported FileInfo.Sys() type") {
+		t.Errorf("got %v, want unsupported FileInfo.Sys() type error", err)
+	}
+}
+
+func TestCheckCaps(t *testing.T) {
+	tests := []struct {
+		uid     int
+		caps    uint64
+		mode    os.FileMode
+		path    string
+		allowed bool
+	}{
+		{1001, 0, Read, "/home/alice/file", false},
+		{1001, CapDACReadSearch, Read, "/home/alice/file", true},
+		{1001, CapDACReadSearch, Write, "/home/alice/file", false},
+		{1001, CapDACOverride, Read | Write, "/home/alice/file", true},
+		{1001, CapDACOverride, Execute, "/home/alice/file", false},
+		{1001, CapDACOverride, Read | Write | Execute, "/home/alice", true},
+		{0, 0, Read, "/home/alice/file", false},
+		{0, rootCaps, Read | Write, "/home/alice/file", true},
+	}
+	for _, tt := range tests {
+		err := New(WithFileSystem(testFS)).checkCaps(tt.uid, []int{tt.uid}, tt.caps, tt.mode, tt.path)
+		if tt.allowed && err != nil {
+			t.Errorf("uid %d, caps %x, mode %o, path %s: %v", tt.uid, tt.caps, tt.mode, tt.path, err)
+		} else if !tt.allowed && err == nil {
+			t.Errorf("uid %d, caps %x, mode %o, path %s: got nil error", tt.uid, tt.caps, tt.mode, tt.path)
+		}
+	}
+}
+
+// statfsFS is a memFS whose filesystems are mounted read-only under some paths
+type statfsFS struct {
+	memFS
+	readOnly []string
+	// filesystem types, by mount point
+	types map[string]string
+}
+
+func (s statfsFS) Statfs(name string) (FSInfo, error) {
+	var info FSInfo
+	for _, p := range s.readOnly {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			info.ReadOnly = true
+		}
+	}
+	for p, typ := range s.types {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			info.Type = typ
+		}
+	}
+	return info, nil
+}
+
+func TestReadOnlyCheck(t *testing.T) {
+	fsys := statfsFS{memFS: testFS, readOnly: []string{"/home"}}
+	c := New(WithFileSystem(fsys), WithReadOnlyCheck(true))
+
+	if err := c.Check(1000, []int{1000}, Read, "/home/alice/file"); err != nil {
+		t.Errorf("read on read-only filesystem: %v", err)
+	}
+	if err := c.Check(1000, []int{1000}, Write, "/home/alice/file"); !errors.Is(err, ErrReadOnlyFS) {
+		t.Errorf("write on read-only filesystem: got %v, want ErrReadOnlyFS", err)
+	}
+	if err := c.Check(0, []int{0}, Write, "/srv/data"); !errors.Is(err, ErrReadOnlyFS) {
+		t.Errorf("root write on read-only filesystem through symlink: got %v, want ErrReadOnlyFS", err)
+	}
+	if err := c.Check(0, []int{0}, Write, "/srv/shared/doc"); err != nil {
+		t.Errorf("write on read-write filesystem: %v", err)
+	}
+	if err := New(WithFileSystem(fsys)).Check(1000, []int{1000}, Write, "/home/alice/file"); err != nil {
+		t.Errorf("write on read-only filesystem without detection: %v", err)
+	}
+}
+
+func TestGuardReadHandler(t *testing.T) {
+	fsys := memFS{
+		"/":             {mode: os.ModeDir | 0755},
+		"/srv":          {mode: os.ModeDir | 0755},
+		"/srv/www":      {mode: os.ModeDir | 0755},
+		"/srv/www/page": {mode: 0644},
+		"/srv/www/dir":  {mode: os.ModeDir | 0755},
+		"/srv/www/out":  {mode: os.ModeSymlink | 0777, link: "/etc/shadow"},
+		"/srv/www/in":   {mode: os.ModeSymlink | 0777, link: "/srv/www/page"},
+		"/etc":          {mode: os.ModeDir | 0755},
+		"/etc/shadow":   {mode: 0600},
+	}
+	c := New(WithFileSystem(fsys))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served")
+	})
+	root := func(*http.Request) (int, error) { return 0, nil }
+	unknown := func(*http.Request) (int, error) { return -2, nil }
+	failing := func(*http.Request) (int, error) { return 0, errors.New("unauthenticated") }
+
+	tests := []struct {
+		uidFor func(*http.Request) (int, error)
+		path   string
+		code   int
+	}{
+		{root, "/page", http.StatusOK},
+		{root, "/dir/../page", http.StatusOK},
+		{root, "/../../etc/shadow", http.StatusNotFound},
+		{root, "/out", http.StatusForbidden},
+		{root, "/in", http.StatusOK},
+		{root, "/missing", http.StatusNotFound},
+		{root, "/page/file", http.StatusNotFound},
+		{unknown, "/page", http.StatusInternalServerError},
+		{failing, "/page", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.URL.Path = tt.path
+		c.GuardReadHandler(tt.uidFor, "/srv/www", next).ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.code)
+		}
+		if (rec.Body.String() == "served") != (tt.code == http.StatusOK) {
+			t.Errorf("%s: got body %q", tt.path, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	httpError(rec, &PermissionError{File: "/srv/www/page"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("permission error: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestSpecialFiles(t *testing.T) {
+	fsys := statfsFS{
+		memFS: memFS{
+			"/":         {mode: os.ModeDir | 0755},
+			"/dev":      {mode: os.ModeDir | 0755},
+			"/dev/null": {mode: os.ModeDevice | os.ModeCharDevice | 0666},
+			"/dev/sda":  {mode: os.ModeDevice | 0660, gid: 6, nlink: 2},
+			"/dev/tty1": {mode: os.ModeDevice | os.ModeCharDevice | 0620, uid: 1000, gid: 5},
+			"/run":      {mode: os.ModeDir | 0755},
+			"/run/fifo": {mode: os.ModeNamedPipe | 0620, uid: 1000, gid: 100},
+			"/run/sock": {mode: os.ModeSocket | 0777},
+			"/run/null": {mode: os.ModeSymlink | 0777, link: "/dev/null"},
+		},
+		readOnly: []string{"/dev", "/run"},
+	}
+	c := New(WithFileSystem(fsys), WithReadOnlyCheck(true), WithRejectMultiplyLinkedWrites(true))
+
+	tests := []struct {
+		uid     int
+		gids    []int
+		mode    os.FileMode
+		path    string
+		granted bool
+	}{
+		{1001, []int{1001}, Read | Write, "/dev/null", true},
+		{1001, []int{1001}, Read | Write, "/run/null", true},
+		{1001, []int{1001}, Read, "/dev/sda", false},
+		{1001, []int{1001, 6}, Read | Write, "/dev/sda", true},
+		{1000, []int{1000}, Read | Write, "/dev/tty1", true},
+		{1001, []int{1001, 5}, Write, "/dev/tty1", true},
+		{1001, []int{1001, 5}, Read, "/dev/tty1", false},
+		{1000, []int{1000}, Read | Write, "/run/fifo", true},
+		{1001, []int{1001, 100}, Write, "/run/fifo", true},
+		{1001, []int{1001, 100}, Read, "/run/fifo", false},
+		{1001, []int{1001}, Write, "/run/sock", true},
+		{0, []int{0}, Read | Write, "/dev/tty1", true},
+		// like regular files, special files can only be executed by root if an execute bit is set
+		{0, []int{0}, Execute, "/dev/null", false},
+		{1001, []int{1001}, Execute, "/run/sock", true},
+	}
+	for _, tt := range tests {
+		err := c.Check(tt.uid, tt.gids, tt.mode, tt.path)
+		var pe *PermissionError
+		if tt.granted && err != nil {
+			t.Errorf("uid %d mode %v on %s: %v", tt.uid, tt.mode, tt.path, err)
+		} else if !tt.granted && !errors.As(err, &pe) {
+			t.Errorf("uid %d mode %v on %s: got %v, want PermissionError", tt.uid, tt.mode, tt.path, err)
+		}
+	}
+
+	var ne *NotDirError
+	if err := c.Check(0, []int{0}, Read, "/dev/null/"); !errors.As(err, &ne) {
+		t.Errorf("device with trailing separator: got %v, want NotDirError", err)
+	}
+	if err := c.Check(0, []int{0}, Read, "/run/fifo/file"); !errors.As(err, &ne) {
+		t.Errorf("path through FIFO: got %v, want NotDirError", err)
+	}
+
+	for path, typ := range map[string]os.FileMode{
+		"/dev/null": os.ModeDevice | os.ModeCharDevice,
+		"/dev/sda":  os.ModeDevice,
+		"/run/fifo": os.ModeNamedPipe,
+		"/run/sock": os.ModeSocket,
+		"/run":      os.ModeDir,
+	} {
+		r, err := c.evaluate(0, []int{0}, Read, path)
+		if err != nil {
+			t.Errorf("evaluate %s: %v", path, err)
+		} else if r.Type != typ {
+			t.Errorf("evaluate %s: got type %v, want %v", path, r.Type, typ)
+		}
+	}
+}
+
+func TestRejectSpecialFS(t *testing.T) {
+	fsys := statfsFS{memFS: testFS, types: map[string]string{"/home": "proc", "/srv": "ext4"}}
+	c := New(WithFileSystem(fsys), WithRejectSpecialFS(true))
+
+	var se *SpecialFSError
+	if err := c.Check(1000, []int{1000}, Read, "/srv/data"); !errors.As(err, &se) || !errors.Is(err, ErrSpecialFS) {
+		t.Errorf("symlink into special filesystem: got %v, want SpecialFSError", err)
+	} else if se.Path != "/home/alice/file" || se.FSType != "proc" {
+		t.Errorf("symlink into special filesystem: got %q on %q, want %q on %q", se.Path, se.FSType, "/home/alice/file", "proc")
+	}
+	if err := c.Check(1001, []int{1001, 100}, Read, "/srv/shared/doc"); err != nil {
+		t.Errorf("regular filesystem: %v", err)
+	}
+	if err := New(WithFileSystem(fsys)).Check(1000, []int{1000}, Read, "/srv/data"); err != nil {
+		t.Errorf("special filesystem without detection: %v", err)
+	}
+}
+
+// attrFS is a memFS with file attributes, keyed by clean absolute paths
+type attrFS struct {
+	memFS
+	attrs map[string]Attrs
+}
+
+func (a attrFS) Attrs(name string) (Attrs, error) {
+	return a.attrs[filepath.Clean(name)], nil
+}
+
+func TestAttrCheck(t *testing.T) {
+	fsys := attrFS{memFS: testFS, attrs: map[string]Attrs{
+		"/home/alice/file": {Immutable: true},
+		"/srv/shared/doc":  {AppendOnly: true},
+	}}
+	c := New(WithFileSystem(fsys), WithAttrCheck(true))
+
+	if err := c.Check(1000, []int{1000}, Read, "/home/alice/file"); err != nil {
+		t.Errorf("read immutable file: %v", err)
+	}
+	if err := c.Check(0, []int{0}, Write, "/home/alice/file"); !errors.Is(err, ErrImmutable) {
+		t.Errorf("write immutable file: got %v, want ErrImmutable", err)
+	}
+	if err := c.Check(0, []int{0}, Write, "/srv/shared/doc"); !errors.Is(err, ErrAppendOnly) {
+		t.Errorf("write append-only file: got %v, want ErrAppendOnly", err)
+	}
+	if err := New(WithFileSystem(fsys)).Check(0, []int{0}, Write, "/home/alice/file"); err != nil {
+		t.Errorf("write immutable file without attribute check: %v", err)
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	fsys := countFS{FileSystem: testFS, lstats: make(map[string]int)}
+	paths := []string{"/home/alice/file", "/srv/data", "/home/alice/link", "/srv/shared/doc", "/home/alice/missing"}
+	errs := New(WithFileSystem(fsys)).checkBatch(1000, []int{1000}, Read, paths)
+	if len(errs) != len(paths) {
+		t.Fatalf("got %d errors, want %d", len(errs), len(paths))
+	}
+	for i, path := range paths {
+		if want := CheckFS(testFS, 1000, []int{1000}, Read, path); fmt.Sprint(errs[i]) != fmt.Sprint(want) {
+			t.Errorf("path %s: got %v, want %v", path, errs[i], want)
+		}
+	}
+	for name, n := range fsys.lstats {
+		if n > 1 {
+			t.Errorf("%s stat'ed %d times", name, n)
+		}
+	}
+}
+
+func TestUidAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	uid := os.Getuid()
+
+	if err := UidAt(dir, uid, Read, "file"); err != nil {
+		t.Errorf("relative path: %v", err)
+	}
+	if err := UidAt(filepath.Join(dir, "sub"), uid, Read, "../file"); err != nil {
+		t.Errorf("relative path with ..: %v", err)
+	}
+	if err := UidAt(filepath.Join(dir, "sub"), uid, Read, "file"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("relative path to missing file: got %v, want ErrNotExist", err)
+	}
+	if err := UidAt(filepath.Join(dir, "sub"), uid, Read, filepath.Join(dir, "file")); err != nil {
+		t.Errorf("absolute path: %v", err)
+	}
+}
+
+func TestCheckAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/sub/file", filepath.Join(dir, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	uid := os.Getuid()
+	if err := CheckAt(fd, uid, []int{os.Getgid()}, Read, "sub/file"); err != nil {
+		if errors.Is(err, ErrUnsupported) {
+			t.Skip(err)
+		}
+		t.Errorf("relative name: %v", err)
+	}
+	if err := CheckAt(fd, uid, []int{os.Getgid()}, Read, "abs"); err != nil {
+		t.Errorf("absolute symlink resolved beneath the directory: %v", err)
+	}
+	if err := CheckAt(fd, uid, []int{os.Getgid()}, Read, "../../sub/file"); err != nil {
+		t.Errorf("name escaping the directory: %v", err)
+	}
+	if err := CheckAt(fd, uid, []int{os.Getgid()}, Read, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want ErrNotExist", err)
+	}
+	if uid == 0 {
+		var pe *PermissionError
+		if err := CheckAt(fd, 1000, []int{1000}, Read, "sub/file"); !errors.As(err, &pe) {
+			t.Errorf("file in private directory: got %v, want PermissionError", err)
+		} else if pe.File != "/sub" {
+			t.Errorf("file in private directory: got PermissionError on %s, want /sub", pe.File)
+		}
+	}
+}
+
+func TestResolvedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		resolved string
+	}{
+		{"/home/alice/file", "/home/alice/file"},
+		{"/srv/data", "/home/alice/file"},
+		{"/srv/rel/link", "/home/alice/file"},
+		{"/srv/rel/missing", ""},
+		{"/srv/shared/doc", "/srv/shared/doc"},
+	}
+	for _, tt := range tests {
+		var pe *PermissionError
+		if err := CheckFS(testFS, 1001, []int{1001}, Read, tt.path); !errors.As(err, &pe) {
+			t.Errorf("path %s: got %v, want PermissionError", tt.path, err)
+		} else if pe.ResolvedPath != tt.resolved {
+			t.Errorf("path %s: got resolved path %q, want %q", tt.path, pe.ResolvedPath, tt.resolved)
+		}
+	}
+}
+
+func TestCheckAny(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	mode, err := c.checkAny(1001, []int{1001, 100}, []os.FileMode{Read, Write}, "/srv/shared/doc")
+	if err != nil || mode != Read {
+		t.Errorf("read or write: got mode %o (error %v), want %o", mode, err, Read)
+	}
+	var pe *PermissionError
+	if _, err := c.checkAny(1001, []int{1001, 100}, []os.FileMode{Write, Execute}, "/srv/shared/doc"); !errors.As(err, &pe) {
+		t.Errorf("write or execute: got %v, want PermissionError", err)
+	} else if pe.WantMode != Write {
+		t.Errorf("write or execute: got PermissionError for mode %o, want %o", pe.WantMode, Write)
+	}
+	if _, err := c.checkAny(1001, []int{1001}, []os.FileMode{Read, Write}, "/home/alice/file"); !errors.As(err, &pe) {
+		t.Errorf("file in private directory: got %v, want PermissionError", err)
+	}
+}
+
+func TestNotExistOrDenied(t *testing.T) {
+	tests := []struct {
+		uid    int
+		path   string
+		denied bool // whether a PermissionError is expected rather than a fs.ErrNotExist error
+	}{
+		{1001, "/home/alice/missing", true},
+		{1001, "/home/alice/missing/child", true},
+		{1001, "/home/alice/file", true},
+		{1001, "/srv/hidden", true},
+		{1000, "/home/alice/missing", false},
+		{1000, "/home/alice/missing/child", false},
+		{1000, "/srv/hidden", false},
+		{1001, "/srv/missing", false},
+		{1001, "/srv/dangling", false},
+		{1001, "/missing/child", false},
+	}
+	for _, tt := range tests {
+		err := CheckFS(testFS, tt.uid, []int{tt.uid}, Read, tt.path)
+		var pe *PermissionError
+		isDenied, isNotExist := errors.As(err, &pe), errors.Is(err, fs.ErrNotExist)
+		if tt.denied && (!isDenied || isNotExist) {
+			t.Errorf("uid %d, path %s: got %v, want PermissionError", tt.uid, tt.path, err)
+		} else if !tt.denied && (isDenied || !isNotExist) {
+			t.Errorf("uid %d, path %s: got %v, want fs.ErrNotExist", tt.uid, tt.path, err)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	steps, err := c.trace(1001, []int{1001}, Write, "/srv/data")
+	want := []Step{
+		{Path: "/", RequiredMode: Execute, FileMode: os.ModeDir | 0755, Granted: true},
+		{Path: "/srv", RequiredMode: Execute, FileMode: os.ModeDir | 0755, Granted: true},
+		{Path: "/home", RequiredMode: Execute, FileMode: os.ModeDir | 0755, Granted: true, LinksWalked: 1},
+		{Path: "/home/alice", RequiredMode: Execute, FileMode: os.ModeDir | 0700, FileUid: 1000, FileGid: 1000, Granted: false, LinksWalked: 1},
+		{Path: "/home/alice/file", RequiredMode: Write, FileMode: 0644, FileUid: 1000, FileGid: 1000, Granted: false, LinksWalked: 1},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %+v, want %+v", steps, want)
+	}
+	var pe *PermissionError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v, want PermissionError", err)
+	}
+	if pe.File != "/home/alice" || pe.ResolvedPath != "/home/alice/file" {
+		t.Errorf("got denial on %q resolved to %q, want %q resolved to %q", pe.File, pe.ResolvedPath, "/home/alice", "/home/alice/file")
+	}
+
+	steps, err = c.trace(1000, []int{1000}, Read, "/home/alice/link")
+	if err != nil {
+		t.Fatalf("owner: got %v", err)
+	}
+	for _, s := range steps {
+		if !s.Granted {
+			t.Errorf("owner: got denied step %+v", s)
+		}
+	}
+}
+
+func TestTraceDevIno(t *testing.T) {
+	fsys := memFS{
+		"/":              {mode: os.ModeDir | 0755, dev: 1, ino: 2},
+		"/mnt":           {mode: os.ModeDir | 0755, dev: 1, ino: 3},
+		"/mnt/data":      {mode: os.ModeDir | 0755, dev: 2, ino: 2},
+		"/mnt/data/a":    {mode: os.ModeSymlink | 0777, dev: 2, ino: 4, link: "b"},
+		"/mnt/data/b":    {mode: os.ModeSymlink | 0777, dev: 2, ino: 5, link: "file"},
+		"/mnt/data/file": {mode: 0644, dev: 2, ino: 6},
+	}
+	c := New(WithFileSystem(fsys))
+
+	steps, err := c.trace(1000, []int{1000}, Read, "/mnt/data/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type devIno struct{ dev, ino uint64 }
+	var got []devIno
+	for _, s := range steps {
+		got = append(got, devIno{s.Dev, s.Ino})
+	}
+	want := []devIno{{1, 2}, {1, 3}, {2, 2}, {2, 6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got devices and inodes %v, want %v", got, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	var paths []string
+	err := c.walkSteps(1001, []int{1001}, Write, "/srv/data", func(s Step) error {
+		paths = append(paths, s.Path)
+		return nil
+	})
+	want := []string{"/", "/srv", "/home", "/home/alice", "/home/alice/file"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths %v, want %v", paths, want)
+	}
+	if !errors.Is(err, ErrPermission) {
+		t.Errorf("got %v, want PermissionError", err)
+	}
+
+	// stop at the first denial, even though the walk would go on
+	stop := errors.New("stop")
+	paths = nil
+	err = c.walkSteps(1001, []int{1001}, Write, "/srv/data", func(s Step) error {
+		paths = append(paths, s.Path)
+		if !s.Granted {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("stopped: got %v, want %v", err, stop)
+	}
+	if want := want[:4]; !reflect.DeepEqual(paths, want) {
+		t.Errorf("stopped: got paths %v, want %v", paths, want)
+	}
+
+	// stop after a denial was already collected
+	paths = nil
+	err = c.walkSteps(1001, []int{1001}, Write, "/srv/data", func(s Step) error {
+		paths = append(paths, s.Path)
+		if s.Path == "/home/alice/file" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("stopped after denial: got %v, want %v", err, stop)
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+	alice := Identity{Uid: 1000, Gids: []int{1000}}
+	bob := Identity{Uid: 1001, Gids: []int{1001}}
+
+	if err := c.checkUser(bob, alice, Read, "/home/alice/file"); err != nil {
+		t.Errorf("effective owner: got %v", err)
+	}
+	var pe *PermissionError
+	if err := c.checkUser(alice, bob, Read, "/home/alice/file"); !errors.As(err, &pe) {
+		t.Errorf("effective other: got %v, want PermissionError", err)
+	} else if pe.Uid != bob.Uid {
+		t.Errorf("effective other: got PermissionError for uid %d, want %d", pe.Uid, bob.Uid)
+	}
+
+	if err := c.canDeleteUser(alice, bob, "/tmp/alice"); err != nil {
+		t.Errorf("real owner in sticky directory: got %v", err)
+	}
+	var se *StickyError
+	if err := c.canDeleteUser(bob, alice, "/tmp/alice"); !errors.As(err, &se) {
+		t.Errorf("real other in sticky directory: got %v, want StickyError", err)
+	} else if se.Uid != bob.Uid {
+		t.Errorf("real other in sticky directory: got StickyError for uid %d, want %d", se.Uid, bob.Uid)
+	}
+}
+
+func TestDenyWorldWritableAncestors(t *testing.T) {
+	fsys := memFS{
+		"/":               {mode: os.ModeDir | 0755},
+		"/tmp":            {mode: os.ModeDir | os.ModeSticky | 0777},
+		"/tmp/file":       {mode: 0666},
+		"/srv":            {mode: os.ModeDir | 0755},
+		"/srv/open":       {mode: os.ModeDir | 0777},
+		"/srv/open/file":  {mode: 0644},
+		"/srv/open/dir":   {mode: os.ModeDir | 0755},
+		"/srv/open/dir/f": {mode: 0644},
+		"/srv/group":      {mode: os.ModeDir | 0775},
+		"/srv/group/file": {mode: 0644},
+		"/srv/link":       {mode: os.ModeSymlink | 0777, link: "open/file"},
+		"/srv/safe":       {mode: os.ModeSymlink | 0777, link: "group/file"},
+	}
+	c := New(WithFileSystem(fsys), WithDenyWorldWritableAncestors(true))
+
+	tests := []struct {
+		path   string
+		unsafe string
+	}{
+		{"/tmp/file", ""},
+		{"/srv/group/file", ""},
+		{"/srv/safe", ""},
+		// the world-writable directory itself is not an ancestor
+		{"/srv/open", ""},
+		{"/srv/open/file", "/srv/open"},
+		{"/srv/open/dir/f", "/srv/open"},
+		{"/srv/link", "/srv/open"},
+	}
+	for _, tt := range tests {
+		err := c.Check(0, []int{0}, Read, tt.path)
+		var ue *UnsafeAncestorError
+		if tt.unsafe == "" && err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else if tt.unsafe != "" && (!errors.As(err, &ue) || !errors.Is(err, ErrUnsafeAncestor)) {
+			t.Errorf("%s: got %v, want UnsafeAncestorError", tt.path, err)
+		} else if tt.unsafe != "" && ue.Path != tt.unsafe {
+			t.Errorf("%s: got unsafe ancestor %q, want %q", tt.path, ue.Path, tt.unsafe)
+		}
+	}
+
+	if err := c.searchOnly(0, []int{0}, "/srv/open/missing"); !errors.Is(err, ErrUnsafeAncestor) {
+		t.Errorf("search: got %v, want ErrUnsafeAncestor", err)
+	}
+	if err := New(WithFileSystem(fsys)).Check(0, []int{0}, Read, "/srv/open/file"); err != nil {
+		t.Errorf("without option: %v", err)
+	}
+}
+
+func TestEvaluateRootBypass(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	tests := []struct {
+		uid      int
+		mode     os.FileMode
+		path     string
+		usedRoot bool
+		denied   bool
+	}{
+		{0, Read, "/srv/shared/doc", false, false},
+		{0, Write, "/srv/shared/doc", false, false},
+		{0, Read | Write, "/tmp/alice", true, false},
+		{0, Read, "/srv/data", true, false},
+		{0, Read, "/srv/setgid", false, false},
+		{0, Execute, "/tmp/alice", false, true},
+		{1000, Read, "/srv/data", false, false},
+		{1001, Read, "/srv/data", false, true},
+	}
+	for _, tt := range tests {
+		usedRoot, err := c.evaluateRootBypass(tt.uid, []int{tt.uid}, tt.mode, tt.path)
+		var pe *PermissionError
+		if tt.denied && !errors.As(err, &pe) {
+			t.Errorf("uid %d mode %v on %s: got %v, want PermissionError", tt.uid, tt.mode, tt.path, err)
+		} else if !tt.denied && err != nil {
+			t.Errorf("uid %d mode %v on %s: %v", tt.uid, tt.mode, tt.path, err)
+		}
+		if usedRoot != tt.usedRoot {
+			t.Errorf("uid %d mode %v on %s: got root bypass %v, want %v", tt.uid, tt.mode, tt.path, usedRoot, tt.usedRoot)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	tests := []struct {
+		uid  int
+		gids []int
+		mode os.FileMode
+		path string
+		want Result
+	}{
+		{1000, []int{1000}, Read | Write, "/srv/data", Result{Allowed: true, ResolvedPath: "/home/alice/file", GrantedVia: "owner", GrantedGid: -1, LinksWalked: 1, Components: 5}},
+		{1001, []int{1001, 100}, Read, "/srv/shared/doc", Result{Allowed: true, ResolvedPath: "/srv/shared/doc", GrantedVia: "group", GrantedGid: 100, Components: 3}},
+		{1001, []int{100, 1001}, Read, "/srv/shared/doc", Result{Allowed: true, ResolvedPath: "/srv/shared/doc", GrantedVia: "group", GrantedGid: 100, Components: 3}},
+		{1001, []int{1001}, Read, "/srv/setgid", Result{Allowed: true, ResolvedPath: "/srv/setgid", GrantedVia: "other", GrantedGid: -1, Type: os.ModeDir, Components: 2}},
+		{0, []int{0}, Write, "/srv/shared/doc", Result{Allowed: true, ResolvedPath: "/srv/shared/doc", GrantedVia: "root", GrantedGid: -1, Components: 3}},
+		{1001, []int{1001}, Read, "/srv/data", Result{ResolvedPath: "/home/alice/file", BlockedBy: "/home/alice", GrantedGid: -1, MissingMode: Execute, LinksWalked: 1, Components: 5}},
+		{1001, []int{1001, 100}, Write, "/srv/shared/doc", Result{ResolvedPath: "/srv/shared/doc", BlockedBy: "/srv/shared/doc", GrantedGid: -1, MissingMode: Write, Components: 3}},
+	}
+	for _, tt := range tests {
+		got, err := c.evaluate(tt.uid, tt.gids, tt.mode, tt.path)
+		if err != nil {
+			t.Errorf("%s (uid %d, mode %o): got error %v", tt.path, tt.uid, tt.mode, err)
+		} else if got != tt.want {
+			t.Errorf("%s (uid %d, mode %o): got %+v, want %+v", tt.path, tt.uid, tt.mode, got, tt.want)
+		}
+	}
+	if _, err := c.evaluate(1000, []int{1000}, Read, "/srv/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestSameTarget(t *testing.T) {
+	fsys := memFS{
+		"/":                {mode: os.ModeDir | 0755, ino: 1},
+		"/home":            {mode: os.ModeDir | 0755, ino: 2},
+		"/home/alice":      {mode: os.ModeDir | 0700, uid: 1000, gid: 1000, ino: 3},
+		"/home/alice/file": {mode: 0644, uid: 1000, gid: 1000, ino: 4},
+		"/home/alice/hard": {mode: 0644, uid: 1000, gid: 1000, ino: 4},
+		"/pub":             {mode: os.ModeDir | 0755, ino: 5},
+		"/pub/hard":        {mode: 0644, uid: 1000, gid: 1000, ino: 4},
+		"/pub/data":        {mode: os.ModeSymlink | 0777, link: "/home/alice/file", ino: 6},
+		"/pub/other":       {mode: 0644, ino: 7},
+	}
+	c := New(WithFileSystem(fsys))
+
+	for _, tt := range []struct {
+		uid  int
+		a, b string
+		same bool
+	}{
+		{1000, "/pub/data", "/home/alice/file", true},
+		{1000, "/home/alice/hard", "/home/alice/file", true},
+		{1000, "/pub/hard", "/home/alice/file", true},
+		{1000, "/pub/other", "/home/alice/file", false},
+		{1001, "/pub/data", "/home/alice/file", true},
+		{1001, "/pub/hard", "/home/alice/file", false},
+	} {
+		if same, err := c.sameTarget(tt.uid, []int{tt.uid}, Read, tt.a, tt.b); err != nil {
+			t.Errorf("uid %d, %s and %s: got %v", tt.uid, tt.a, tt.b, err)
+		} else if same != tt.same {
+			t.Errorf("uid %d, %s and %s: got %v, want %v", tt.uid, tt.a, tt.b, same, tt.same)
+		}
+	}
+	if _, err := c.sameTarget(1000, []int{1000}, Read, "/pub/other", "/pub/missing"); !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "/pub/missing") {
+		t.Errorf("missing path: got %v, want ErrNotExist mentioning the path", err)
+	}
+}
+
+func TestEvaluateSource(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	for _, tt := range []struct {
+		uid    int
+		gids   []int
+		mode   os.FileMode
+		path   string
+		source string
+	}{
+		{1000, []int{1000}, Read | Write, "/srv/data", "owner"},
+		{1001, []int{1001, 100}, Read, "/srv/shared/doc", "group"},
+		{1001, []int{1001}, Write, "/srv/setgid", "other"},
+		{0, []int{0}, Write, "/srv/shared/doc", "root"},
+	} {
+		if source, err := c.evaluateSource(tt.uid, tt.gids, tt.mode, tt.path); err != nil {
+			t.Errorf("uid %d, path %s: got %v", tt.uid, tt.path, err)
+		} else if source != tt.source {
+			t.Errorf("uid %d, path %s: got source %q, want %q", tt.uid, tt.path, source, tt.source)
+		}
+	}
+	var pe *PermissionError
+	if source, err := c.evaluateSource(1001, []int{1001}, Read, "/srv/data"); !errors.As(err, &pe) || source != "" {
+		t.Errorf("denied: got source %q and %v, want PermissionError", source, err)
+	}
+}
+
+func TestGroupname(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	if err := c.group(100, Read, "/srv/shared/doc"); err != nil {
+		t.Errorf("group member: got %v", err)
+	}
+	var pe *PermissionError
+	if err := c.group(100, Write, "/srv/shared/doc"); !errors.As(err, &pe) {
+		t.Errorf("group member write: got %v, want PermissionError", err)
+	}
+	if err := c.group(1000, Read, "/home/alice/file"); !errors.As(err, &pe) {
+		t.Errorf("owner group without owner bits: got %v, want PermissionError", err)
+	} else if pe.File != "/home/alice" || pe.Uid != -1 {
+		t.Errorf("owner group without owner bits: got denial on %q for uid %d, want %q for uid -1", pe.File, pe.Uid, "/home/alice")
+	}
+	if err := c.group(0, Read, "/srv/shared/doc"); !errors.As(err, &pe) {
+		t.Errorf("root group: got %v, want PermissionError", err)
+	}
+}
+
+func TestPublicAccess(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	if err := c.publicAccess(Read|Write, "/tmp"); err != nil {
+		t.Errorf("world-writable directory: got %v", err)
+	}
+	var pe *PermissionError
+	for _, tt := range []struct {
+		mode os.FileMode
+		path string
+		file string
+	}{
+		{Write, "/srv", "/srv"},
+		{Read, "/srv/shared/doc", "/srv/shared"},
+		{Read, "/srv/data", "/home/alice"},
+		{Read, "/tmp/alice", "/tmp/alice"},
+	} {
+		if err := c.publicAccess(tt.mode, tt.path); !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", tt.path, err)
+		} else if pe.File != tt.file || pe.Uid != -1 {
+			t.Errorf("%s: got denial on %q for uid %d, want %q for uid -1", tt.path, pe.File, pe.Uid, tt.file)
+		}
+	}
+	if err := c.publicAccess(Read, "/tmp/alice/child"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("file with child: got %v, want ENOTDIR", err)
+	}
+}
+
+func TestPrimaryGroupOnly(t *testing.T) {
+	supplementary := []int{1001, 100}
+	primary := []int{100, 1001}
+
+	c := New(WithFileSystem(testFS))
+	if err := c.Check(1001, supplementary, Read, "/srv/shared/doc"); err != nil {
+		t.Errorf("supplementary group: got %v", err)
+	}
+
+	c = New(WithFileSystem(testFS), WithPrimaryGroupOnly(true))
+	var pe *PermissionError
+	if err := c.Check(1001, supplementary, Read, "/srv/shared/doc"); !errors.As(err, &pe) {
+		t.Errorf("supplementary group, primary only: got %v, want PermissionError", err)
+	} else if pe.File != "/srv/shared" || !reflect.DeepEqual(pe.Gid, []int{1001}) {
+		t.Errorf("supplementary group, primary only: got denial on %q for gids %v, want %q for gids [1001]", pe.File, pe.Gid, "/srv/shared")
+	}
+	if err := c.Check(1001, primary, Read, "/srv/shared/doc"); err != nil {
+		t.Errorf("primary group, primary only: got %v", err)
+	}
+}
+
+func TestMaxGroups(t *testing.T) {
+	gids := []int{1001, 4, 27, 100}
+
+	for _, tt := range []struct {
+		max     int
+		granted bool
+	}{
+		{max: -1, granted: true},
+		{max: 3, granted: true},
+		{max: 2, granted: false},
+		{max: 0, granted: false},
+	} {
+		c := New(WithFileSystem(testFS), WithMaxGroups(tt.max))
+		err := c.Check(1001, gids, Read, "/srv/shared/doc")
+		var pe *PermissionError
+		if tt.granted && err != nil {
+			t.Errorf("max %d: got %v", tt.max, err)
+		} else if !tt.granted && !errors.As(err, &pe) {
+			t.Errorf("max %d: got %v, want PermissionError", tt.max, err)
+		} else if !tt.granted && !reflect.DeepEqual(pe.Gid, gids[:1+tt.max]) {
+			t.Errorf("max %d: got denial for gids %v, want %v", tt.max, pe.Gid, gids[:1+tt.max])
+		}
+	}
+
+	// the primary group is always considered
+	c := New(WithFileSystem(testFS), WithMaxGroups(0))
+	if err := c.Check(1001, []int{100, 1001}, Read, "/srv/shared/doc"); err != nil {
+		t.Errorf("primary group, max 0: got %v", err)
+	}
+}
+
+func TestCheckResolved(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	if err := c.checkResolved(1000, []int{1000}, Read|Write, "/home/alice/file"); err != nil {
+		t.Errorf("owner: got %v", err)
+	}
+	var pe *PermissionError
+	if err := c.checkResolved(1001, []int{1001}, Read, "/home/alice/file"); !errors.As(err, &pe) {
+		t.Errorf("other: got %v, want PermissionError", err)
+	} else if pe.File != "/home/alice" {
+		t.Errorf("other: got denial on %q, want %q", pe.File, "/home/alice")
+	}
+	// the symlink is not followed: its own permissions are checked
+	if err := c.checkResolved(1001, []int{1001}, Read, "/srv/data"); err != nil {
+		t.Errorf("unresolved symlink: got %v", err)
+	}
+}
+
+func TestRejectMultiplyLinkedWrites(t *testing.T) {
+	fsys := memFS{
+		"/":       {mode: os.ModeDir | 0755, nlink: 3},
+		"/single": {mode: 0666, nlink: 1},
+		"/linked": {mode: 0666, nlink: 2},
+	}
+
+	r, err := New(WithFileSystem(fsys)).evaluate(1000, []int{1000}, Read, "/linked")
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Nlink != 2 {
+		t.Errorf("evaluate: got %d links, want 2", r.Nlink)
+	}
+	if err := New(WithFileSystem(fsys)).Check(1000, []int{1000}, Write, "/linked"); err != nil {
+		t.Errorf("disabled: got %v", err)
+	}
+
+	c := New(WithFileSystem(fsys), WithRejectMultiplyLinkedWrites(true))
+	if err := c.Check(1000, []int{1000}, Write, "/linked"); !errors.Is(err, ErrMultiplyLinked) {
+		t.Errorf("write linked: got %v, want ErrMultiplyLinked", err)
+	}
+	if err := c.Check(1000, []int{1000}, Read, "/linked"); err != nil {
+		t.Errorf("read linked: got %v", err)
+	}
+	if err := c.Check(1000, []int{1000}, Write, "/single"); err != nil {
+		t.Errorf("write single: got %v", err)
+	}
+	if err := c.Check(0, []int{0}, Write, "/"); err != nil {
+		t.Errorf("write directory: got %v", err)
+	}
+}
+
+func TestResolveSymlinkFree(t *testing.T) {
+	fsys := memFS{
+		"/":        {mode: os.ModeDir | 0755},
+		"/a":       {mode: os.ModeSymlink | 0777, link: "/b"},
+		"/b":       {mode: os.ModeDir | 0755},
+		"/b/f":     {mode: 0644},
+		"/b/d":     {mode: os.ModeDir | 0755},
+		"/b/d/up":  {mode: os.ModeSymlink | 0777, link: "../../a/.."},
+		"/b/d/abs": {mode: os.ModeSymlink | 0777, link: "/a/d/../f"},
+		"/b/d/mix": {mode: os.ModeSymlink | 0777, link: "../d/../../a/../c"},
+		"/c":       {mode: os.ModeDir | 0755},
+		"/c/g":     {mode: 0644},
+		"/e":       {mode: os.ModeSymlink | 0777, link: "a/d"},
+	}
+	c := New(WithFileSystem(fsys))
+
+	// the .. of the requested path are cleaned lexically, like filepath.Abs does, but the
+	// .. of the symlink targets are resolved against the resolved components
+	for _, tt := range []struct {
+		path string
+		dest string
+	}{
+		{"/a/../c", "/c"},
+		{"/a/../c/g", "/c/g"},
+		{"/a/d/../f", "/b/f"},
+		{"/a/d/../../c/g", "/c/g"},
+		{"/e/up/c/g", "/c/g"},
+		{"/e/up/a/f", "/b/f"},
+		{"/a/d/abs", "/b/f"},
+		{"/e/abs", "/b/f"},
+		{"/e/mix/g", "/c/g"},
+		{"/a/d/up/c/g", "/c/g"},
+	} {
+		w := c.newWalk(context.Background(), 0, []int{0})
+		dest, err := w.resolve(tt.path, true)
+		if err != nil {
+			t.Errorf("%s: got %v", tt.path, err)
+			continue
+		}
+		if dest != tt.dest {
+			t.Errorf("%s: got %q, want %q", tt.path, dest, tt.dest)
+		}
+		// the final walk slices dest on separators: none of its components may be a symlink
+		for p := dest; ; p = filepath.Dir(p) {
+			if fi, err := fsys.Lstat(p); err != nil {
+				t.Errorf("%s: component %s: %v", tt.path, p, err)
+			} else if fi.Mode()&os.ModeSymlink != 0 {
+				t.Errorf("%s: component %s of %s is a symlink", tt.path, p, dest)
+			}
+			if p == "/" {
+				break
+			}
+		}
+	}
+}
+
+func TestCheckOwnership(t *testing.T) {
+	fsys := memFS{
+		"/":                 {mode: os.ModeDir | 0755},
+		"/home":             {mode: os.ModeDir | 0755},
+		"/home/alice":       {mode: os.ModeDir | 0700, uid: 1000, gid: 1000},
+		"/home/alice/file":  {mode: 0644, uid: 1000, gid: 1000},
+		"/home/alice/group": {mode: 0664, uid: 1000, gid: 1000},
+		"/home/alice/bob":   {mode: 0644, uid: 1001, gid: 1001},
+		"/home/alice/link":  {mode: os.ModeSymlink | 0777, uid: 1001, gid: 1001, link: "file"},
+		"/srv":              {mode: os.ModeDir | 0755},
+		"/srv/doc":          {mode: 0644, uid: 1000, gid: 1000},
+	}
+	c := New(WithFileSystem(fsys))
+
+	for _, path := range []string{"/home/alice/file", "/home/alice/link"} {
+		if err := c.checkOwnership(1000, []int{1000}, path, "/home/alice"); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+	for _, tt := range []struct {
+		path   string
+		stopAt string
+		file   string
+	}{
+		{"/home/alice/file", "/home", "/home"},
+		{"/home/alice/group", "/home/alice", "/home/alice/group"},
+		{"/home/alice/bob", "/home/alice", "/home/alice/bob"},
+	} {
+		var oe *OwnershipError
+		if err := c.checkOwnership(1000, []int{1000}, tt.path, tt.stopAt); !errors.As(err, &oe) || !errors.Is(err, ErrUnsafeOwnership) {
+			t.Errorf("%s up to %s: got %v, want OwnershipError", tt.path, tt.stopAt, err)
+		} else if oe.Path != tt.file {
+			t.Errorf("%s up to %s: got OwnershipError on %q, want %q", tt.path, tt.stopAt, oe.Path, tt.file)
+		}
+	}
+	var pe *PermissionError
+	if err := c.checkOwnership(1001, []int{1001}, "/home/alice/bob", "/home/alice"); !errors.As(err, &pe) {
+		t.Errorf("unreadable: got %v, want PermissionError", err)
+	}
+	if err := c.checkOwnership(1000, []int{1000}, "/srv/doc", "/home/alice"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("outside stopAt: got %v, want ErrOutsideRoot", err)
+	}
+}
+
+// deniedFS is a FileSystem that the calling process is denied reading below a directory
+type deniedFS struct {
+	FileSystem
+	dir string
+}
+
+func (d deniedFS) Lstat(name string) (os.FileInfo, error) {
+	if strings.HasPrefix(name, d.dir+"/") {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: syscall.EACCES}
+	}
+	return d.FileSystem.Lstat(name)
+}
+
+func (d deniedFS) Readlink(name string) (string, error) {
+	if strings.HasPrefix(name, d.dir+"/") {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EACCES}
+	}
+	return d.FileSystem.Readlink(name)
+}
+
+func TestCheckerDenied(t *testing.T) {
+	c := New(WithFileSystem(deniedFS{FileSystem: testFS, dir: "/home/alice"}))
+
+	var ce *CheckerDeniedError
+	err := c.Check(1000, []int{1000}, Read, "/home/alice/file")
+	if !errors.As(err, &ce) || !errors.Is(err, ErrCheckerDenied) || !errors.Is(err, syscall.EACCES) {
+		t.Errorf("got %v, want CheckerDeniedError", err)
+	} else if ce.Path != "/home/alice/file" {
+		t.Errorf("got CheckerDeniedError on %q, want %q", ce.Path, "/home/alice/file")
+	}
+	if errors.Is(err, ErrPermission) {
+		t.Errorf("got %v, want no ErrPermission", err)
+	}
+
+	// the checked user cannot search the directory either: its denial is reported
+	for _, path := range []string{"/home/alice/file", "/srv/data", "/srv/rel/link"} {
+		var pe *PermissionError
+		if err := c.Check(1001, []int{1001}, Read, path); !errors.As(err, &pe) || errors.Is(err, ErrCheckerDenied) {
+			t.Errorf("%s: got %v, want PermissionError", path, err)
+		} else if pe.File != "/home/alice" {
+			t.Errorf("%s: got denial on %q, want %q", path, pe.File, "/home/alice")
+		}
+		if err := c.checkAll(1001, []int{1001}, Read, path); !errors.As(err, &pe) || errors.Is(err, ErrCheckerDenied) {
+			t.Errorf("%s: all denials: got %v, want PermissionError", path, err)
+		}
+	}
+}
+
+func TestCheckSafeDir(t *testing.T) {
+	fsys := memFS{
+		"/":                 {mode: os.ModeDir | 0755},
+		"/home":             {mode: os.ModeDir | 0755},
+		"/home/alice":       {mode: os.ModeDir | 0700, uid: 1000, gid: 1000},
+		"/home/alice/file":  {mode: 0600, uid: 1000, gid: 1000},
+		"/home/alice/group": {mode: os.ModeDir | 0770, uid: 1000, gid: 1000},
+		"/home/alice/link":  {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "."},
+		"/home/bob":         {mode: os.ModeDir | 0755, uid: 1001, gid: 1001},
+		"/tmp":              {mode: os.ModeDir | os.ModeSticky | 0777},
+		"/tmp/alice":        {mode: os.ModeDir | 0700, uid: 1000, gid: 1000},
+		"/var":              {mode: os.ModeDir | 0777},
+		"/var/spool":        {mode: os.ModeDir | 0755},
+	}
+	c := New(WithFileSystem(fsys))
+
+	for _, path := range []string{"/home/alice", "/tmp", "/tmp/alice", "/home"} {
+		if err := c.checkSafeDir(1000, []int{1000}, path); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+	var se *SymlinkError
+	if err := c.checkSafeDir(1000, []int{1000}, "/home/alice/link"); !errors.As(err, &se) {
+		t.Errorf("symlink: got %v, want SymlinkError", err)
+	}
+	if err := c.checkSafeDir(1000, []int{1000}, "/home/alice/file"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("file: got %v, want NotDirError", err)
+	}
+	var oe *OwnershipError
+	if err := c.checkSafeDir(1000, []int{1000}, "/home/bob"); !errors.As(err, &oe) || oe.Writable {
+		t.Errorf("other owner: got %v, want OwnershipError", err)
+	}
+	if err := c.checkSafeDir(1000, []int{1000}, "/home/alice/group"); !errors.As(err, &oe) || !oe.Writable {
+		t.Errorf("group-writable: got %v, want OwnershipError", err)
+	}
+	var ae *UnsafeAncestorError
+	if err := c.checkSafeDir(1000, []int{1000}, "/var/spool"); !errors.As(err, &ae) || !errors.Is(err, ErrUnsafeAncestor) {
+		t.Errorf("world-writable ancestor: got %v, want UnsafeAncestorError", err)
+	} else if ae.Path != "/var" {
+		t.Errorf("world-writable ancestor: got UnsafeAncestorError on %q, want %q", ae.Path, "/var")
+	}
+}
+
+// racingFS is a memFS whose file at path is replaced by a new inode each time it is stat'ed,
+// for its first 2*changes stats, so that the first changes checks see it change once each
+type racingFS struct {
+	memFS
+	path    string
+	changes int
+	lstats  *int
+}
+
+func (r racingFS) Lstat(name string) (os.FileInfo, error) {
+	fi, err := r.memFS.Lstat(name)
+	if err != nil || filepath.Clean(name) != r.path {
+		return fi, err
+	}
+	*r.lstats++
+	f := r.memFS[r.path]
+	f.ino = uint64(*r.lstats)
+	if *r.lstats > 2*r.changes {
+		f.ino = 0
+	}
+	return memFileInfo{name: filepath.Base(r.path), f: f}, nil
+}
+
+func TestConsistencyRetries(t *testing.T) {
+	for _, tt := range []struct {
+		changes int
+		retries int
+		raced   bool
+	}{
+		{changes: 0, retries: 0},
+		{changes: 1, retries: 0},
+		{changes: 0, retries: 1},
+		{changes: 1, retries: 1},
+		{changes: 2, retries: 1, raced: true},
+		{changes: 3, retries: 2, raced: true},
+		{changes: 3, retries: 3},
+	} {
+		lstats := 0
+		fsys := racingFS{memFS: testFS, path: "/home/alice/file", changes: tt.changes, lstats: &lstats}
+		c := New(WithFileSystem(fsys), WithConsistencyRetries(tt.retries))
+		err := c.Check(1000, []int{1000}, Read, "/srv/data")
+		if tt.raced && !errors.Is(err, ErrRaced) {
+			t.Errorf("%d changes, %d retries: got %v, want ErrRaced", tt.changes, tt.retries, err)
+		} else if !tt.raced && err != nil {
+			t.Errorf("%d changes, %d retries: %v", tt.changes, tt.retries, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	tests := []struct {
+		uid  int
+		path string
+		want string
+	}{
+		{1000, "/srv/data", "/home/alice/file"},
+		{1000, "/srv/rel/link", "/home/alice/file"},
+		{1000, "/srv/rel/../../tmp/alice", "/tmp/alice"},
+		{1001, "/tmp/alice", "/tmp/alice"},
+	}
+	for _, tt := range tests {
+		got, err := c.resolve(tt.uid, []int{tt.uid}, tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var pe *PermissionError
+	if _, err := c.resolve(1001, []int{1001}, "/srv/rel/link"); !errors.As(err, &pe) || pe.File != "/home/alice" {
+		t.Errorf("unsearchable directory: got %v, want PermissionError on /home/alice", err)
+	}
+	if _, err := c.resolve(1000, []int{1000}, "/srv/hidden"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("dangling symlink: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestStatFor(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	fi, err := c.statFor(1000, []int{1000}, "/srv/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" || !fi.Mode().IsRegular() {
+		t.Errorf("symlink to file: got %q with mode %v, want regular file %q", fi.Name(), fi.Mode(), "file")
+	}
+	if fi, err := c.statFor(1000, []int{1000}, "/srv/rel"); err != nil || !fi.IsDir() {
+		t.Errorf("symlink to directory: got %v, want directory", err)
+	}
+	var pe *PermissionError
+	if _, err := c.statFor(1001, []int{1001}, "/srv/data"); !errors.As(err, &pe) || pe.File != "/home/alice" {
+		t.Errorf("unsearchable directory: got %v, want PermissionError on /home/alice", err)
+	}
+	if _, err := c.statFor(1000, []int{1000}, "/srv/dangling"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("dangling symlink: got %v, want fs.ErrNotExist", err)
+	}
+	// no permission is checked on the file itself
+	if _, err := c.statFor(1001, []int{1001}, "/tmp/alice"); err != nil {
+		t.Errorf("unreadable file: %v", err)
+	}
+}
+
+func TestSearchOnly(t *testing.T) {
+	fsys := memFS{
+		"/":            {mode: os.ModeDir | 0755},
+		"/drop":        {mode: os.ModeDir | 0711},
+		"/drop/file":   {mode: 0644},
+		"/drop/secret": {mode: 0600},
+		"/drop/closed": {mode: os.ModeDir | 0700},
+		"/drop/link":   {mode: os.ModeSymlink | 0777, link: "closed"},
+	}
+	c := New(WithFileSystem(fsys))
+
+	// reading a file only requires searching its ancestors, not reading them
+	if err := c.Check(1000, []int{1000}, Read, "/drop/file"); err != nil {
+		t.Errorf("read in search-only directory: got %v", err)
+	}
+	for _, path := range []string{"/drop/file", "/drop/secret", "/drop/missing", "/drop/closed", "/drop/link", "/drop"} {
+		if err := c.searchOnly(1000, []int{1000}, path); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+	var pe *PermissionError
+	for _, path := range []string{"/drop/closed/file", "/drop/link/file"} {
+		if err := c.searchOnly(1000, []int{1000}, path); !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", path, err)
+		} else if pe.File != "/drop/closed" {
+			t.Errorf("%s: got denial on %q, want %q", path, pe.File, "/drop/closed")
+		}
+	}
+}
+
+func TestDisallowSymlinks(t *testing.T) {
+	c := New(WithFileSystem(testFS), WithDisallowSymlinks(true))
+
+	if err := c.check(context.Background(), 1000, []int{1000}, Read, "/home/alice/file", true); err != nil {
+		t.Errorf("no symlink: got %v", err)
+	}
+	for _, tt := range []struct {
+		path   string
+		follow bool
+		link   string
+	}{
+		{"/srv/data", true, "/srv/data"},
+		{"/srv/rel/file", true, "/srv/rel"},
+		{"/home/alice/link", false, "/home/alice/link"},
+	} {
+		err := c.check(context.Background(), 1000, []int{1000}, Read, tt.path, tt.follow)
+		var se *SymlinkError
+		if !errors.As(err, &se) {
+			t.Errorf("%s: got %v, want SymlinkError", tt.path, err)
+		} else if se.Path != tt.link {
+			t.Errorf("%s: got SymlinkError for %q, want %q", tt.path, se.Path, tt.link)
+		}
+		if !errors.Is(err, ErrSymlink) {
+			t.Errorf("%s: got %v, want ErrSymlink", tt.path, err)
+		}
+	}
+}
+
+func TestAllowedSymlinkTargets(t *testing.T) {
+	c := New(WithFileSystem(testFS), WithAllowedSymlinkTargets([]string{"/tmp", "/home/alice/"}))
+	for _, path := range []string{"/srv/data", "/srv/rel/file", "/home/alice/link", "/home/alice/file"} {
+		if err := c.Check(1000, []int{1000}, Read, path); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+
+	c = New(WithFileSystem(testFS), WithAllowedSymlinkTargets([]string{"/tmp", "/home/alice/file"}))
+	var se *SymlinkTargetError
+	if err := c.Check(1000, []int{1000}, Read, "/srv/rel/file"); !errors.As(err, &se) || !errors.Is(err, ErrSymlinkTargetNotAllowed) {
+		t.Errorf("disallowed target: got %v, want SymlinkTargetError", err)
+	} else if se.Path != "/srv/rel" || se.Link != "../home/alice" || se.Target != "/home/alice" {
+		t.Errorf("disallowed target: got %s -> %s (%s), want %s -> %s (%s)", se.Path, se.Link, se.Target, "/srv/rel", "../home/alice", "/home/alice")
+	}
+	if err := c.Check(1000, []int{1000}, Read, "/srv/data"); err != nil {
+		t.Errorf("allowed file target: got %v", err)
+	}
+}
+
+func TestCheckWithin(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	if err := c.checkWithin("/srv", 1000, []int{1000}, Read, "/srv/shared/../setgid"); err != nil {
+		t.Errorf("path within root: got %v", err)
+	}
+	if err := c.checkWithin("/", 1000, []int{1000}, Read, "/srv/data"); err != nil {
+		t.Errorf("root /: got %v", err)
+	}
+	for _, tt := range []struct {
+		path    string
+		outside string
+	}{
+		{"/srv/data", "/home/alice/file"},
+		{"/srv/rel/file", "/"},
+		{"/srv/../home/alice/file", "/home"},
+		{"/home/alice/file", "/home"},
+		{"/", "/"},
+	} {
+		err := c.checkWithin("/srv", 1000, []int{1000}, Read, tt.path)
+		var oe *OutsideRootError
+		if !errors.As(err, &oe) {
+			t.Errorf("%s: got %v, want OutsideRootError", tt.path, err)
+		} else if oe.Path != tt.outside || oe.Root != "/srv" {
+			t.Errorf("%s: got %q outside %q, want %q outside %q", tt.path, oe.Path, oe.Root, tt.outside, "/srv")
+		}
+	}
+	if err := c.checkWithin("srv", 1000, []int{1000}, Read, "/srv"); err == nil {
+		t.Errorf("relative root: got no error")
+	}
+
+	// absolute symlinks are walked again from /, through the ancestors of root
+	fsys := memFS{
+		"/srv/abs":    {mode: os.ModeSymlink | 0777, link: "/srv/shared/doc"},
+		"/srv/absdir": {mode: os.ModeSymlink | 0777, link: "/srv/shared"},
+		"/srv/detour": {mode: os.ModeSymlink | 0777, link: "/home/../srv/shared/doc"},
+		"/srv/escape": {mode: os.ModeSymlink | 0777, link: "/srv/../home/alice/file"},
+	}
+	for name, f := range testFS {
+		fsys[name] = f
+	}
+	c = New(WithFileSystem(fsys))
+	shared := []int{1000, 100}
+	if err := c.checkWithin("/srv", 1000, shared, Read, "/srv/abs"); err != nil {
+		t.Errorf("absolute symlink within root: got %v", err)
+	}
+	if err := c.checkWithin("/srv", 1000, shared, Read, "/srv/absdir/doc"); err != nil {
+		t.Errorf("absolute symlink to directory within root: got %v", err)
+	}
+	var oe *OutsideRootError
+	if err := c.checkWithin("/srv", 1000, shared, Read, "/srv/detour"); !errors.As(err, &oe) || oe.Path != "/home" {
+		t.Errorf("absolute symlink within root through outside: got %v, want OutsideRootError on /home", err)
+	}
+	if err := c.checkWithin("/srv", 1000, shared, Read, "/srv/escape"); !errors.As(err, &oe) || oe.Path != "/home/alice/file" {
+		t.Errorf("absolute symlink outside root: got %v, want OutsideRootError on /home/alice/file", err)
+	}
+}
+
+func TestIdentityForUid(t *testing.T) {
+	id, err := IdentityForUid(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Uid != 0 || !contains(id.Gids, 0) {
+		t.Errorf("root: got identity %+v, want uid 0 in group 0", id)
+	}
+	if err := CheckIdentity(id, Read|Write, os.TempDir()); err != nil {
+		t.Errorf("root read/write on temporary directory: %v", err)
+	}
+	if _, err := IdentityForUid(-2); err == nil {
+		t.Errorf("unknown user: got nil error")
+	}
+}
+
+func TestGetgrouplist(t *testing.T) {
+	c := New(WithGetgrouplist(true))
+	id, err := c.IdentityForUid(0)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("getgrouplist is not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := IdentityForUid(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Uid != 0 || len(id.Gids) == 0 || id.Gids[0] != want.Gids[0] {
+		t.Errorf("root: got identity %+v, want primary group %d first", id, want.Gids[0])
+	}
+	for _, g := range want.Gids {
+		if !contains(id.Gids, g) {
+			t.Errorf("root: got groups %v, missing group %d", id.Gids, g)
+		}
+	}
+}
+
+func TestClassPrecedence(t *testing.T) {
+	fsys := memFS{
+		"/":      {mode: os.ModeDir | 0755},
+		"/owner": {mode: 0077, uid: 1000, gid: 100},
+		"/group": {mode: 0707, uid: 1000, gid: 100},
+	}
+	c := New(WithFileSystem(fsys))
+
+	tests := []struct {
+		path    string
+		uid     int
+		gids    []int
+		granted bool
+	}{
+		{"/owner", 1000, []int{1000, 100}, false},
+		{"/owner", 1001, []int{1001, 100}, true},
+		{"/owner", 1002, []int{1002}, true},
+		{"/group", 1000, []int{1000, 100}, true},
+		{"/group", 1001, []int{1001, 100}, false},
+		{"/group", 1002, []int{1002}, true},
+	}
+	for _, tt := range tests {
+		err := c.check(context.Background(), tt.uid, tt.gids, Read, tt.path, true)
+		if tt.granted && err != nil {
+			t.Errorf("%s (uid %d, gids %v): got %v", tt.path, tt.uid, tt.gids, err)
+		}
+		var pe *PermissionError
+		if !tt.granted && !errors.As(err, &pe) {
+			t.Errorf("%s (uid %d, gids %v): got %v, want PermissionError", tt.path, tt.uid, tt.gids, err)
+		}
+	}
+}
+
+func TestCheckUsers(t *testing.T) {
+	fsys := countFS{FileSystem: testFS, lstats: make(map[string]int)}
+	c := New(WithFileSystem(fsys))
+
+	errs := c.checkUsers([]Identity{
+		{Uid: 1000, Gids: []int{1000}},
+		{Uid: 1001, Gids: []int{1001, 100}},
+		{Uid: 0, Gids: []int{0}},
+	}, Read, "/srv/shared/doc")
+	if len(errs) != 3 {
+		t.Fatalf("got %d results, want 3", len(errs))
+	}
+	var pe *PermissionError
+	if !errors.As(errs[1000], &pe) || pe.File != "/srv/shared" {
+		t.Errorf("uid 1000: got %v, want PermissionError on the directory", errs[1000])
+	}
+	if errs[1001] != nil {
+		t.Errorf("uid 1001: got %v", errs[1001])
+	}
+	if errs[0] != nil {
+		t.Errorf("uid 0: got %v", errs[0])
+	}
+	for name, n := range fsys.lstats {
+		if n != 1 {
+			t.Errorf("%s: stat'ed %d times, want 1", name, n)
+		}
+	}
+}
+
+func TestCanCreateFile(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	if err := c.canCreateFile(1000, []int{1000}, "/home/alice/new"); err != nil {
+		t.Errorf("new file in own directory: got %v", err)
+	}
+	if err := c.canCreateFile(1000, []int{1000}, "/home/alice/file"); err != nil {
+		t.Errorf("existing own file: got %v", err)
+	}
+	if err := c.canCreateFile(1001, []int{1001}, "/srv/setgid/new"); err != nil {
+		t.Errorf("new file in world-writable directory: got %v", err)
+	}
+	var pe *PermissionError
+	if err := c.canCreateFile(1001, []int{1001, 100}, "/srv/shared/new"); !errors.As(err, &pe) || pe.File != "/srv/shared" {
+		t.Errorf("new file in read-only directory: got %v, want PermissionError on the directory", err)
+	}
+	if err := c.canCreateFile(1001, []int{1001, 100}, "/srv/shared/doc"); !errors.As(err, &pe) || pe.File != "/srv/shared/doc" {
+		t.Errorf("existing read-only file: got %v, want PermissionError on the file", err)
+	}
+	if err := c.canCreateFile(1001, []int{1001}, "/home/alice/new"); !errors.As(err, &pe) || pe.File != "/home/alice" {
+		t.Errorf("new file in private directory: got %v, want PermissionError on the directory", err)
+	}
+}
+
+func TestRootDirectory(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	for _, path := range []string{"/", "//", "/srv/../"} {
+		steps, err := c.trace(1001, []int{1001}, Execute, path)
+		if err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+		want := []Step{{Path: "/", RequiredMode: Execute, FileMode: os.ModeDir | 0755, Granted: true}}
+		if !reflect.DeepEqual(steps, want) {
+			t.Errorf("%s: got steps %+v, want %+v", path, steps, want)
+		}
+	}
+
+	// the root directory is checked as the parent of its children, even without resolution
+	private := memFS{
+		"/":    {mode: os.ModeDir | 0700},
+		"/srv": {mode: os.ModeDir | 0755},
+	}
+	w := New(WithFileSystem(private)).newWalk(context.Background(), 1001, []int{1001})
+	var pe *PermissionError
+	if err := w.checkPath(Execute, "/srv"); !errors.As(err, &pe) || pe.File != "/" {
+		t.Errorf("private root: got %v, want PermissionError on /", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	// ids that are unlikely to exist, so that they are not replaced by names
+	const uid, gid = 0x7ffffffe, 0x7ffffffd
+	pe := &PermissionError{
+		File:         "/home/alice",
+		FileMode:     os.ModeDir | 0700,
+		FileUid:      uid - 1,
+		FileGid:      gid - 1,
+		Uid:          uid,
+		Gid:          []int{gid},
+		WantMode:     Execute,
+		MissingMode:  Execute,
+		ResolvedPath: "/home/alice/file",
+	}
+	want := "uid 2147483646 cannot search the directory /home/alice (to access /home/alice/file): it has mode drwx------ and is owned by user uid 2147483645 and group gid 2147483644, so the permissions of other users (---) apply to uid 2147483646, which lack search"
+	if got := pe.Explain(); got != want {
+		t.Errorf("other: got %q, want %q", got, want)
+	}
+
+	pe = &PermissionError{File: "/f", FileMode: 0177, FileUid: uid, FileGid: gid, Uid: uid, Gid: []int{gid}, WantMode: Read | Write, MissingMode: Read | Write}
+	if got := pe.Explain(); !strings.Contains(got, "cannot read and write the file /f:") || !strings.Contains(got, "the permissions of its owner (--x) apply") || !strings.Contains(got, "which lack read and write") {
+		t.Errorf("owner: got %q", got)
+	}
+
+	root := &PermissionError{File: "/f", FileMode: 0644, Uid: 0, Gid: []int{0}, WantMode: Execute, MissingMode: Execute}
+	if got := root.Explain(); !strings.HasPrefix(got, "root cannot execute the file /f: it has mode -rw-r--r-- and is owned by user root and group root") {
+		t.Errorf("root: got %q", got)
+	}
+}
+
+func TestModeStrings(t *testing.T) {
+	for _, tt := range []struct {
+		fm   os.FileMode
+		want string
+	}{
+		{os.ModeDir | 0750, "drwxr-x---"},
+		{os.ModeDir | os.ModeSticky | 0777, "drwxrwxrwx"},
+		{0644, "-rw-r--r--"},
+		{os.ModeSetuid | 0755, "-rwxr-xr-x"},
+		{os.ModeSymlink | 0777, "lrwxrwxrwx"},
+		{os.ModeNamedPipe | 0600, "prw-------"},
+		{os.ModeDevice | os.ModeCharDevice | 0666, "crw-rw-rw-"},
+		{os.ModeDevice | 0660, "brw-rw----"},
+	} {
+		pe := &PermissionError{FileMode: tt.fm}
+		if got := pe.FileModeString(); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.fm, got, tt.want)
+		}
+	}
+	pe := &PermissionError{WantMode: Read | Execute}
+	if got := pe.WantModeString(); got != "r-x" {
+		t.Errorf("want mode: got %q, want %q", got, "r-x")
+	}
+}
+
+func TestStayOnDevice(t *testing.T) {
+	fsys := memFS{
+		"/":               {mode: os.ModeDir | 0755, dev: 1, ino: 2},
+		"/srv":            {mode: os.ModeDir | 0755, dev: 1, ino: 3},
+		"/srv/file":       {mode: 0644, dev: 1, ino: 4},
+		"/srv/mount":      {mode: os.ModeDir | 0755, dev: 2, ino: 2},
+		"/srv/mount/file": {mode: 0644, dev: 2, ino: 3},
+		"/srv/link":       {mode: os.ModeSymlink | 0777, dev: 1, ino: 5, link: "mount/file"},
+	}
+
+	r, err := New(WithFileSystem(fsys)).evaluate(1000, []int{1000}, Read, "/srv/link")
+	if err != nil || !r.Allowed || r.Dev != 2 || r.Ino != 3 {
+		t.Errorf("device and inode: got %+v (error %v), want device 2 and inode 3", r, err)
+	}
+
+	c := New(WithFileSystem(fsys), WithStayOnDevice(true))
+	if err := c.check(context.Background(), 1000, []int{1000}, Read, "/srv/file", true); err != nil {
+		t.Errorf("same device: got %v", err)
+	}
+	for _, path := range []string{"/srv/mount/file", "/srv/link"} {
+		err := c.check(context.Background(), 1000, []int{1000}, Read, path, true)
+		var ce *CrossDeviceError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrCrossDevice) {
+			t.Errorf("%s: got %v, want CrossDeviceError", path, err)
+		} else if ce.Path != "/srv/mount" || ce.Dev != 2 || ce.RootDev != 1 {
+			t.Errorf("%s: got %+v, want /srv/mount on device 2 instead of 1", path, ce)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fsys := memFS{
+		"/":          {mode: os.ModeDir | 0755},
+		"/dir":       {mode: os.ModeDir | 0644},
+		"/dir/file":  {mode: 0644},
+		"/tool":      {mode: 0755},
+		"/listing":   {mode: os.ModeDir | 0711},
+		"/listing/x": {mode: 0644},
+	}
+	c := New(WithFileSystem(fsys))
+	check := func(mode os.FileMode, path string) error {
+		return c.check(context.Background(), 1000, []int{1000}, mode, path, true)
+	}
+
+	if Search != Execute {
+		t.Fatalf("Search is %o, want Execute (%o)", Search, Execute)
+	}
+	// a directory that cannot be searched denies Execute, and access to the files below it
+	var pe *PermissionError
+	if err := check(Execute, "/dir"); !errors.As(err, &pe) || pe.WantMode != Search {
+		t.Errorf("execute on unsearchable directory: got %v, want PermissionError for Search", err)
+	}
+	if err := check(Read, "/dir/file"); !errors.As(err, &pe) || pe.File != "/dir" || pe.WantMode != Search {
+		t.Errorf("file in unsearchable directory: got %v, want PermissionError for Search on the directory", err)
+	}
+	// a directory that can be searched but not read grants Execute, and access to the files below it
+	if err := check(Search, "/listing"); err != nil {
+		t.Errorf("search on unreadable directory: got %v", err)
+	}
+	if err := check(Read, "/listing"); !errors.As(err, &pe) {
+		t.Errorf("read on unreadable directory: got %v, want PermissionError", err)
+	}
+	if err := check(Read, "/listing/x"); err != nil {
+		t.Errorf("file in unreadable directory: got %v", err)
+	}
+	// on files, Search and Execute are both the execute permission
+	if err := check(Search, "/tool"); err != nil {
+		t.Errorf("search on executable file: got %v", err)
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	fsys := memFS{
+		"/":          {mode: os.ModeDir | 0755},
+		"/a":         {mode: os.ModeDir | 0700},
+		"/a/b":       {mode: os.ModeDir | 0755},
+		"/a/b/c":     {mode: os.ModeDir | 0700},
+		"/a/b/c/doc": {mode: 0600},
+	}
+	c := New(WithFileSystem(fsys))
+
+	err := c.checkAll(1000, []int{1000}, Read, "/a/b/c/doc")
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("got %v, want joined errors", err)
+	}
+	var files []string
+	for _, err := range joined.Unwrap() {
+		var pe *PermissionError
+		if !errors.As(err, &pe) {
+			t.Fatalf("got %v, want PermissionError", err)
+		}
+		if pe.ResolvedPath != "/a/b/c/doc" {
+			t.Errorf("%s: got resolved path %q, want %q", pe.File, pe.ResolvedPath, "/a/b/c/doc")
+		}
+		files = append(files, pe.File)
+	}
+	if want := []string{"/a", "/a/b/c", "/a/b/c/doc"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got denials on %v, want %v", files, want)
+	}
+	if !errors.Is(err, ErrPermission) {
+		t.Errorf("got %v, want ErrPermission", err)
+	}
+
+	if err := c.checkAll(0, []int{0}, Read, "/a/b/c/doc"); err != nil {
+		t.Errorf("root: got %v", err)
+	}
+	if err := c.checkAll(0, []int{0}, Read, "/a/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestSuggestFix(t *testing.T) {
+	c := New(WithFileSystem(testFS))
+
+	steps, err := c.suggestFix(1001, []int{1001, 100}, Read|Write, "/srv/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FixStep{
+		{Path: "/home/alice", Class: "other", Add: 0001, Mode: os.ModeDir | 0701},
+		{Path: "/home/alice/file", Class: "other", Add: 0002, Mode: 0646},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("other: got %+v, want %+v", steps, want)
+	}
+
+	steps, err = c.suggestFix(1001, []int{1001, 100}, Write, "/srv/shared/doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []FixStep{{Path: "/srv/shared/doc", Class: "group", Add: 0020, Mode: 0660}}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("group: got %+v, want %+v", steps, want)
+	}
+
+	if steps, err := c.suggestFix(1000, []int{1000}, Read, "/srv/data"); err != nil || len(steps) != 0 {
+		t.Errorf("granted: got %+v (error %v), want no steps", steps, err)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	w := New(WithFileSystem(testFS)).newWalk(context.Background(), 1000, []int{1000})
+
+	for _, tt := range []struct {
+		path    string
+		follow  bool
+		notDir  string
+		resolve string
+	}{
+		{path: "/home/alice/file/", follow: true, notDir: "/home/alice/file"},
+		{path: "/srv/data/", follow: true, notDir: "/home/alice/file"},
+		{path: "/srv/data//", follow: false, notDir: "/home/alice/file"},
+		{path: "/srv/rel/", follow: false, resolve: "/home/alice"},
+		{path: "/srv/rel", follow: false, resolve: "/srv/rel"},
+		{path: "/srv//shared//", follow: true, resolve: "/srv/shared"},
+		{path: "/srv/shared/", follow: true, resolve: "/srv/shared"},
+	} {
+		dest, err := w.resolve(tt.path, tt.follow)
+		var nde *NotDirError
+		if tt.notDir != "" {
+			if !errors.As(err, &nde) || !errors.Is(err, syscall.ENOTDIR) {
+				t.Errorf("%s: got %v, want NotDirError", tt.path, err)
+			} else if nde.Path != tt.notDir {
+				t.Errorf("%s: got NotDirError on %q, want %q", tt.path, nde.Path, tt.notDir)
+			}
+		} else if err != nil {
+			t.Errorf("%s: got %v", tt.path, err)
+		} else if dest != tt.resolve {
+			t.Errorf("%s: resolved to %q, want %q", tt.path, dest, tt.resolve)
+		}
+	}
+}
+
+func TestParseGroupMembership(t *testing.T) {
+	const groups = `# comment
+root:x:0:
+wheel:x:10:root,alice
+svc:x:998:
+docker:x:999:bob,alice
+malformed:x:1000
+alice:x:1000:alice
+`
+	u := &user.User{Uid: "1000", Gid: "1000", Username: "alice"}
+	gids, err := parseGroupMembership(u, strings.NewReader(groups))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1000, 10, 999}; !reflect.DeepEqual(gids, want) {
+		t.Errorf("got %v, want %v", gids, want)
+	}
+
+	err = &GroupLookupError{Username: "alice", Uid: "1000", Err: syscall.ENOENT}
+	if !errors.Is(err, syscall.ENOENT) || !strings.Contains(err.Error(), "groups of user alice") {
+		t.Errorf("got %v, want wrapped ENOENT", err)
+	}
+}
+
+func TestParseId(t *testing.T) {
+	for _, tt := range []struct {
+		s  string
+		id int
+	}{
+		{"1000", 1000},
+		{" 1000\n", 1000},
+		{"01000", 1000},
+		{"0", 0},
+	} {
+		if id, err := parseId("uid", tt.s); err != nil || id != tt.id {
+			t.Errorf("%q: got %d, %v, want %d", tt.s, id, err, tt.id)
+		}
+	}
+	for _, s := range []string{"", "-1", "10 00", "alice", "4294967296"} {
+		if _, err := parseId("uid", s); err == nil || !strings.Contains(err.Error(), "malformed uid") {
+			t.Errorf("%q: got %v, want malformed uid error", s, err)
+		}
+	}
+}
+
+func TestMaxSymlinkEscapes(t *testing.T) {
+	fsys := memFS{
+		"/":          {mode: os.ModeDir | 0755},
+		"/etc":       {mode: os.ModeDir | 0755},
+		"/etc/conf":  {mode: 0644},
+		"/up":        {mode: os.ModeDir | 0755},
+		"/up/file":   {mode: 0644},
+		"/up/inside": {mode: os.ModeSymlink | 0777, link: "file"},
+		"/up/out":    {mode: os.ModeSymlink | 0777, link: "../etc/conf"},
+		"/up/chain":  {mode: os.ModeSymlink | 0777, link: "/up/out"},
+		"/up/back":   {mode: os.ModeSymlink | 0777, link: "/etc/back"},
+		"/etc/back":  {mode: os.ModeSymlink | 0777, link: "/up/out"},
+	}
+	check := func(max int, path string) error {
+		c := New(WithFileSystem(fsys), WithMaxSymlinkEscapes(max))
+		return c.check(context.Background(), 1000, []int{1000}, Read, path, true)
+	}
+
+	for _, path := range []string{"/up/file", "/up/inside"} {
+		if err := check(0, path); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+	for _, path := range []string{"/up/out", "/up/chain"} {
+		if err := check(1, path); err != nil {
+			t.Errorf("%s with 1 escape allowed: got %v", path, err)
+		}
+		var se *SymlinkEscapeError
+		if err := check(0, path); !errors.As(err, &se) || !errors.Is(err, ErrSymlinkEscape) {
+			t.Errorf("%s: got %v, want SymlinkEscapeError", path, err)
+		} else if se.Path != "/up/out" || se.Escapes != 1 {
+			t.Errorf("%s: got escape %d on %q, want escape 1 on %q", path, se.Escapes, se.Path, "/up/out")
+		}
+	}
+	// /up/back escapes, /etc/back is outside and leads back inside, and /up/out escapes again
+	if err := check(2, "/up/back"); err != nil {
+		t.Errorf("/up/back with 2 escapes allowed: got %v", err)
+	}
+	var se *SymlinkEscapeError
+	if err := check(1, "/up/back"); !errors.As(err, &se) || se.Escapes != 2 {
+		t.Errorf("/up/back with 1 escape allowed: got %v, want SymlinkEscapeError for escape 2", err)
+	}
+	if err := check(-1, "/up/back"); err != nil {
+		t.Errorf("/up/back without limit: got %v", err)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(name, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	uid, gid := os.Getuid(), os.Getgid()
+
+	// the private directory is not checked
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFile(f, uid+1, []int{gid}, Read); err != nil {
+		t.Errorf("group read: %v", err)
+	}
+	var pe *PermissionError
+	if err := CheckFile(f, uid+1, []int{gid}, Write); !errors.As(err, &pe) {
+		t.Errorf("group write: got %v, want PermissionError", err)
+	} else if pe.File != name || pe.MissingMode != Write {
+		t.Errorf("group write: got denial on %q missing %o, want %q missing %o", pe.File, pe.MissingMode, name, Write)
+	}
+	if err := CheckFile(f, uid+1, []int{gid + 1}, Read); !errors.As(err, &pe) {
+		t.Errorf("other read: got %v, want PermissionError", err)
+	}
+
+	// the options of the Checker apply
+	if err := CheckFile(f, uid+1, []int{gid + 1, gid}, Read); err != nil {
+		t.Errorf("supplementary group read: %v", err)
+	}
+	c := New(WithPrimaryGroupOnly(true))
+	if err := c.CheckFile(f, uid+1, []int{gid + 1, gid}, Read); !errors.As(err, &pe) {
+		t.Errorf("primary group only read: got %v, want PermissionError", err)
+	}
+}
+
+func TestAbsPathWithoutWorkingDirectory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	// the working directory no longer exists
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, err := absPath("/srv//shared/../data/"); err != nil || path != "/srv/data" {
+		t.Errorf("absolute path: got %q (error %v), want %q", path, err, "/srv/data")
+	}
+	if err := Check(0, []int{0}, Read, os.TempDir()); err != nil {
+		t.Errorf("absolute path: got %v", err)
+	}
+}
+
+func TestEmptyAndRelativePath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := memFS{filepath.Join(wd, "foo"): {mode: 0600, uid: 1000, gid: 1000}}
+	for p := wd; ; p = filepath.Dir(p) {
+		fsys[p] = memFile{mode: os.ModeDir | 0755}
+		if p == string(os.PathSeparator) {
+			break
+		}
+	}
+	c := New(WithFileSystem(fsys))
+
+	if err := c.Check(1000, []int{1000}, Read, ""); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("empty path: got %v, want ErrEmptyPath", err)
+	}
+	if _, err := c.evaluate(1000, []int{1000}, Read, ""); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("evaluate empty path: got %v, want ErrEmptyPath", err)
+	}
+	if err := c.checkResolved(1000, []int{1000}, Read, ""); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("check resolved empty path: got %v, want ErrEmptyPath", err)
+	}
+	if err := c.searchOnly(1000, []int{1000}, ""); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("search empty path: got %v, want ErrEmptyPath", err)
+	}
+	if err := c.canRename(1000, []int{1000}, "foo", ""); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("rename to empty path: got %v, want ErrEmptyPath", err)
+	}
+
+	// a single component is relative to the working directory
+	if got, err := c.resolve(1000, []int{1000}, "foo"); err != nil || got != filepath.Join(wd, "foo") {
+		t.Errorf("relative path: got %q (error %v), want %q", got, err, filepath.Join(wd, "foo"))
+	}
+	var pe *PermissionError
+	if err := c.Check(1001, []int{1001}, Read, "foo"); !errors.As(err, &pe) || pe.File != filepath.Join(wd, "foo") {
+		t.Errorf("relative path: got %v, want PermissionError on %q", err, filepath.Join(wd, "foo"))
+	}
+}
+
+func TestUidCanonical(t *testing.T) {
+	for _, path := range []string{"srv", "./srv", "/srv/", "/srv//data", "/srv/./data", "/srv/../srv", ""} {
+		var ne *NonCanonicalPathError
+		if err := UidCanonical(0, Read, path); !errors.As(err, &ne) || !errors.Is(err, ErrNonCanonicalPath) {
+			t.Errorf("%q: got %v, want NonCanonicalPathError", path, err)
+		} else if ne.Path != path {
+			t.Errorf("%q: got NonCanonicalPathError for %q", path, ne.Path)
+		}
+	}
+	for _, path := range []string{"/", filepath.Clean(os.TempDir())} {
+		if err := UidCanonical(0, Read, path); err != nil {
+			t.Errorf("%q: got %v", path, err)
+		}
+	}
+}
+
+func TestUidHome(t *testing.T) {
+	if err := UidHome(0, Read, "/etc"); err == nil {
+		t.Errorf("absolute path: got nil, want error")
+	}
+	u, err := user.LookupId("0")
+	if err != nil || u.HomeDir == "" {
+		t.Skipf("cannot look up the home directory of root: %v", err)
+	}
+	if _, err := os.Stat(u.HomeDir); err != nil {
+		t.Skipf("cannot stat the home directory of root: %v", err)
+	}
+	if err := UidHome(0, Read, "."); err != nil {
+		t.Errorf("home directory: got %v", err)
+	}
+	if err := UidHome(0, Read, "access-missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package access
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// statOf returns the system-specific stat data of a file
+func statOf(fi os.FileInfo) (sysStat, error) {
+	s, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return sysStat{}, fmt.Errorf("access: unsupported FileInfo.Sys() type %T", fi.Sys())
+	}
+	return statFromSys(s), nil
+}
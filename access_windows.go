@@ -0,0 +1,13 @@
+package access
+
+import (
+	"os"
+)
+
+// statOf returns the system-specific stat data of a file
+//
+// Windows files are not owned by uids and gids, and their permissions are defined by ACLs,
+// which are not supported yet.
+func statOf(fi os.FileInfo) (sysStat, error) {
+	return sysStat{}, ErrUnsupported
+}
@@ -0,0 +1,154 @@
+package access
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// extended attribute holding the POSIX access ACL of a file
+const aclAccessXattr = "system.posix_acl_access"
+
+// extended attribute holding the POSIX default ACL of a directory, inherited by the files created in it
+const aclDefaultXattr = "system.posix_acl_default"
+
+// POSIX ACL xattr version
+const aclVersion = 2
+
+// POSIX ACL entry tags
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+type aclEntry struct {
+	tag  uint16
+	perm os.FileMode
+	id   int
+}
+
+// acl is a POSIX ACL
+type acl []aclEntry
+
+// parseACL parses a POSIX ACL in the Linux xattr format: a little-endian u32 version,
+// followed by entries of a u16 tag, a u16 permission, and a u32 id
+func parseACL(b []byte) (acl, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return nil, errors.New("access: malformed POSIX ACL")
+	}
+	if v := binary.LittleEndian.Uint32(b); v != aclVersion {
+		return nil, fmt.Errorf("access: unsupported POSIX ACL version %d", v)
+	}
+	b = b[4:]
+	a := make(acl, 0, len(b)/8)
+	for ; len(b) > 0; b = b[8:] {
+		a = append(a, aclEntry{
+			tag:  binary.LittleEndian.Uint16(b),
+			perm: os.FileMode(binary.LittleEndian.Uint16(b[2:]) & 7),
+			id:   int(binary.LittleEndian.Uint32(b[4:])),
+		})
+	}
+	return a, nil
+}
+
+// grants reports whether the ACL grants mode to the user, following the POSIX.1e access check algorithm
+func (a acl) grants(uid int, gids []int, fileUid int, fileGid int, mode os.FileMode) bool {
+	mask := os.FileMode(7)
+	for _, e := range a {
+		if e.tag == aclMask {
+			mask = e.perm
+		}
+	}
+
+	if uid == fileUid {
+		for _, e := range a {
+			if e.tag == aclUserObj {
+				return e.perm&mode == mode
+			}
+		}
+		return false
+	}
+
+	for _, e := range a {
+		if e.tag == aclUser && e.id == uid {
+			return e.perm&mask&mode == mode
+		}
+	}
+
+	matched := false
+	for _, e := range a {
+		if (e.tag == aclGroupObj && contains(gids, fileGid)) || (e.tag == aclGroup && contains(gids, e.id)) {
+			matched = true
+			if e.perm&mask&mode == mode {
+				return true
+			}
+		}
+	}
+	if matched {
+		return false
+	}
+
+	for _, e := range a {
+		if e.tag == aclOther {
+			return e.perm&mode == mode
+		}
+	}
+	return false
+}
+
+// createMode returns the permissions of a file created with mode in a directory whose default
+// ACL is a: like the kernel, the owner, group (or mask) and other entries are restricted by the
+// corresponding bits of mode, and the umask is ignored
+func (a acl) createMode(mode os.FileMode) os.FileMode {
+	owner, group, other := (mode>>6)&7, (mode>>3)&7, mode&7
+	var groupObj, mask os.FileMode
+	hasMask := false
+	for _, e := range a {
+		switch e.tag {
+		case aclUserObj:
+			owner &= e.perm
+		case aclGroupObj:
+			groupObj = e.perm
+		case aclMask:
+			mask, hasMask = e.perm, true
+		case aclOther:
+			other &= e.perm
+		}
+	}
+	if hasMask {
+		group &= mask
+	} else {
+		group &= groupObj
+	}
+	return owner<<6 | group<<3 | other
+}
+
+// posixACL returns the POSIX access ACL of a file, or nil if it has none
+func (w *walk) posixACL(path string, fi os.FileInfo) (acl, error) {
+	return w.readACL(path, fi, aclAccessXattr)
+}
+
+// posixDefaultACL returns the POSIX default ACL of a directory, or nil if it has none
+func (w *walk) posixDefaultACL(path string, fi os.FileInfo) (acl, error) {
+	return w.readACL(path, fi, aclDefaultXattr)
+}
+
+func (w *walk) readACL(path string, fi os.FileInfo, attr string) (acl, error) {
+	xfs, ok := w.c.fs.(XattrFileSystem)
+	if !ok || fi.Mode()&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	b, err := xfs.Getxattr(path, attr)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return parseACL(b)
+}
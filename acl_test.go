@@ -0,0 +1,305 @@
+//go:build !windows
+// +build !windows
+
+package access
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// xattrFS is a memFS with extended attributes, keyed by clean absolute paths then by attribute
+type xattrFS struct {
+	memFS
+	xattrs map[string]map[string][]byte
+}
+
+func (x xattrFS) Getxattr(name string, attr string) ([]byte, error) {
+	return x.xattrs[filepath.Clean(name)][attr], nil
+}
+
+func aclBlob(entries ...aclEntry) []byte {
+	b := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(b, aclVersion)
+	for _, e := range entries {
+		var eb [8]byte
+		binary.LittleEndian.PutUint16(eb[:], e.tag)
+		binary.LittleEndian.PutUint16(eb[2:], uint16(e.perm))
+		binary.LittleEndian.PutUint32(eb[4:], uint32(e.id))
+		b = append(b, eb[:]...)
+	}
+	return b
+}
+
+var aclTestFS = xattrFS{
+	memFS: memFS{
+		"/":         {mode: os.ModeDir | 0755},
+		"/acl":      {mode: os.ModeDir | 0750},
+		"/acl/file": {mode: 0640},
+	},
+	xattrs: map[string]map[string][]byte{
+		"/acl": {
+			aclAccessXattr: aclBlob(
+				aclEntry{tag: aclUserObj, perm: 7},
+				aclEntry{tag: aclUser, perm: 5, id: 1001},
+				aclEntry{tag: aclGroupObj, perm: 0},
+				aclEntry{tag: aclMask, perm: 5},
+				aclEntry{tag: aclOther, perm: 0},
+			),
+		},
+		"/acl/file": {
+			aclAccessXattr: aclBlob(
+				aclEntry{tag: aclUserObj, perm: 6},
+				aclEntry{tag: aclUser, perm: 6, id: 1001},
+				aclEntry{tag: aclGroupObj, perm: 0},
+				aclEntry{tag: aclGroup, perm: 4, id: 100},
+				aclEntry{tag: aclMask, perm: 4},
+				aclEntry{tag: aclOther, perm: 0},
+			),
+		},
+	},
+}
+
+func TestPOSIXACL(t *testing.T) {
+	c := New(WithFileSystem(aclTestFS), WithPOSIXACL(true))
+
+	if err := c.Check(1001, []int{1001}, Read, "/acl/file"); err != nil {
+		t.Errorf("named user read: %v", err)
+	}
+	if err := c.Check(1002, []int{1002, 100}, Read, "/acl/file"); err == nil {
+		t.Errorf("named group read without search on directory: got nil error")
+	}
+	var pe *PermissionError
+	if err := c.Check(1001, []int{1001}, Write, "/acl/file"); !errors.As(err, &pe) {
+		t.Errorf("named user write masked: got %v, want PermissionError", err)
+	}
+	if err := c.Check(1002, []int{1002}, Read, "/acl/file"); !errors.As(err, &pe) {
+		t.Errorf("other user read: got %v, want PermissionError", err)
+	}
+	if err := New(WithFileSystem(aclTestFS)).Check(1001, []int{1001}, Read, "/acl/file"); !errors.As(err, &pe) {
+		t.Errorf("named user read without ACL support: got %v, want PermissionError", err)
+	}
+}
+
+func TestPOSIXACLMask(t *testing.T) {
+	fsys := xattrFS{
+		memFS: memFS{
+			"/":           {mode: os.ModeDir | 0755},
+			"/masked":     {mode: 0640, uid: 1000, gid: 100},
+			"/restricted": {mode: 0644, uid: 1000, gid: 100},
+		},
+		xattrs: map[string]map[string][]byte{
+			// u::rw- u:1001:rwx g::rwx g:200:rwx m::r-- o::---
+			"/masked": {
+				aclAccessXattr: aclBlob(
+					aclEntry{tag: aclUserObj, perm: 6},
+					aclEntry{tag: aclUser, perm: 7, id: 1001},
+					aclEntry{tag: aclGroupObj, perm: 7},
+					aclEntry{tag: aclGroup, perm: 7, id: 200},
+					aclEntry{tag: aclMask, perm: 4},
+					aclEntry{tag: aclOther, perm: 0},
+				),
+			},
+			// u::rw- u:1001:--- g::--- m::r-- o::r--
+			"/restricted": {
+				aclAccessXattr: aclBlob(
+					aclEntry{tag: aclUserObj, perm: 6},
+					aclEntry{tag: aclUser, perm: 0, id: 1001},
+					aclEntry{tag: aclGroupObj, perm: 0},
+					aclEntry{tag: aclMask, perm: 4},
+					aclEntry{tag: aclOther, perm: 4},
+				),
+			},
+		},
+	}
+	c := New(WithFileSystem(fsys), WithPOSIXACL(true))
+
+	tests := []struct {
+		name    string
+		uid     int
+		gids    []int
+		mode    os.FileMode
+		path    string
+		granted bool
+	}{
+		{"owner read write", 1000, []int{1000}, Read | Write, "/masked", true},
+		{"named user read", 1001, []int{1001}, Read, "/masked", true},
+		{"named user write masked", 1001, []int{1001}, Write, "/masked", false},
+		{"named user execute masked", 1001, []int{1001}, Execute, "/masked", false},
+		{"named group read", 1002, []int{1002, 200}, Read, "/masked", true},
+		{"named group write masked", 1002, []int{1002, 200}, Write, "/masked", false},
+		{"owning group read", 1002, []int{1002, 100}, Read, "/masked", true},
+		{"owning group write masked", 1002, []int{1002, 100}, Write, "/masked", false},
+		{"other read", 1003, []int{1003}, Read, "/masked", false},
+		// the permission bits grant read to these users, but the ACL does not
+		{"owning group entry below mask", 1002, []int{1002, 100}, Read, "/restricted", false},
+		{"named user entry before other", 1001, []int{1001}, Read, "/restricted", false},
+		{"other read without entry", 1003, []int{1003}, Read, "/restricted", true},
+		{"owner read write without entry", 1000, []int{1000}, Read | Write, "/restricted", true},
+	}
+	for _, tt := range tests {
+		err := c.Check(tt.uid, tt.gids, tt.mode, tt.path)
+		if tt.granted && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		var pe *PermissionError
+		if !tt.granted && !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", tt.name, err)
+		}
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	if _, err := parseACL([]byte{2, 0, 0, 0, 1}); err == nil {
+		t.Errorf("truncated ACL: got nil error")
+	}
+	if _, err := parseACL([]byte{1, 0, 0, 0}); err == nil {
+		t.Errorf("unknown ACL version: got nil error")
+	}
+	a, err := parseACL(aclBlob(aclEntry{tag: aclUser, perm: 5, id: 1001}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0] != (aclEntry{tag: aclUser, perm: 5, id: 1001}) {
+		t.Errorf("got %v, want a single named user entry", a)
+	}
+}
+
+func nfs4ACLBlob(entries ...nfs4ACE) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(entries)))
+	for _, e := range entries {
+		var eb [16]byte
+		binary.BigEndian.PutUint32(eb[:], e.typ)
+		binary.BigEndian.PutUint32(eb[4:], e.flag)
+		binary.BigEndian.PutUint32(eb[8:], e.mask)
+		binary.BigEndian.PutUint32(eb[12:], uint32(len(e.who)))
+		b = append(b, eb[:]...)
+		b = append(b, e.who...)
+		b = append(b, make([]byte, (4-len(e.who)%4)%4)...)
+	}
+	return b
+}
+
+var nfs4ACLTestFS = xattrFS{
+	memFS: memFS{
+		"/":          {mode: os.ModeDir | 0755},
+		"/nfs":       {mode: os.ModeDir | 0700},
+		"/nfs/file":  {mode: 0600, gid: 100},
+		"/nfs/other": {mode: 0600},
+	},
+	xattrs: map[string]map[string][]byte{
+		"/nfs": {
+			nfs4ACLXattr: nfs4ACLBlob(
+				nfs4ACE{typ: nfs4AccessAllowed, mask: nfs4ReadData | nfs4Execute, who: "EVERYONE@"},
+			),
+		},
+		"/nfs/file": {
+			nfs4ACLXattr: nfs4ACLBlob(
+				nfs4ACE{typ: nfs4AccessDenied, mask: nfs4WriteData, who: "1001"},
+				nfs4ACE{typ: nfs4AccessAllowed, flag: nfs4IdentifierGroup, mask: nfs4ReadData | nfs4WriteData | nfs4AppendData, who: "GROUP@"},
+				nfs4ACE{typ: nfs4AccessAllowed, flag: nfs4IdentifierGroup, mask: nfs4ReadData, who: "200"},
+			),
+		},
+	},
+}
+
+func TestNFSv4ACL(t *testing.T) {
+	c := New(WithFileSystem(nfs4ACLTestFS), WithNFSv4ACL(true))
+
+	if err := c.Check(1002, []int{1002, 100}, Read|Write, "/nfs/file"); err != nil {
+		t.Errorf("group read/write: %v", err)
+	}
+	if err := c.Check(1002, []int{1002, 200}, Read, "/nfs/file"); err != nil {
+		t.Errorf("numeric group read: %v", err)
+	}
+	var pe *PermissionError
+	if err := c.Check(1001, []int{1001, 100}, Write, "/nfs/file"); !errors.As(err, &pe) {
+		t.Errorf("denied user write: got %v, want PermissionError", err)
+	}
+	if err := c.Check(1001, []int{1001, 100}, Read, "/nfs/file"); err != nil {
+		t.Errorf("denied user read: %v", err)
+	}
+	if err := c.Check(1002, []int{1002, 200}, Write, "/nfs/file"); !errors.As(err, &pe) {
+		t.Errorf("numeric group write: got %v, want PermissionError", err)
+	}
+	if err := c.Check(1002, []int{1002}, Read, "/nfs/other"); !errors.As(err, &pe) || pe.File != "/nfs/other" {
+		t.Errorf("file without ACL: got %v, want PermissionError on the file", err)
+	}
+	if err := New(WithFileSystem(nfs4ACLTestFS)).Check(1002, []int{1002, 100}, Read, "/nfs/file"); !errors.As(err, &pe) {
+		t.Errorf("group read without ACL support: got %v, want PermissionError", err)
+	}
+}
+
+func TestParseNFS4ACL(t *testing.T) {
+	if _, err := parseNFS4ACL([]byte{0, 0, 0, 1}); err == nil {
+		t.Errorf("truncated ACL: got nil error")
+	}
+	b := nfs4ACLBlob(nfs4ACE{typ: nfs4AccessAllowed, mask: nfs4ReadData, who: "OWNER@"})
+	if _, err := parseNFS4ACL(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated who: got nil error")
+	}
+	a, err := parseNFS4ACL(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0] != (nfs4ACE{typ: nfs4AccessAllowed, mask: nfs4ReadData, who: "OWNER@"}) {
+		t.Errorf("got %v, want a single owner entry", a)
+	}
+}
+
+func TestDefaultACL(t *testing.T) {
+	fsys := xattrFS{
+		memFS: memFS{
+			"/":        {mode: os.ModeDir | 0755},
+			"/plain":   {mode: os.ModeDir | 0777},
+			"/shared":  {mode: os.ModeDir | 0777},
+			"/private": {mode: os.ModeDir | 0777},
+		},
+		xattrs: map[string]map[string][]byte{
+			"/shared": {
+				aclDefaultXattr: aclBlob(
+					aclEntry{tag: aclUserObj, perm: 7},
+					aclEntry{tag: aclGroupObj, perm: 5},
+					aclEntry{tag: aclGroup, perm: 7, id: 100},
+					aclEntry{tag: aclMask, perm: 7},
+					aclEntry{tag: aclOther, perm: 0},
+				),
+			},
+			"/private": {
+				aclDefaultXattr: aclBlob(
+					aclEntry{tag: aclUserObj, perm: 6},
+					aclEntry{tag: aclGroupObj, perm: 4},
+					aclEntry{tag: aclOther, perm: 0},
+				),
+			},
+		},
+	}
+
+	tests := []struct {
+		path       string
+		acl        bool
+		umask      os.FileMode
+		mode       os.FileMode
+		defaultACL bool
+	}{
+		{"/plain/file", true, 0, 0666, false},
+		{"/shared/file", true, 0, 0660, true},
+		{"/private/file", true, 0, 0640, true},
+		{"/shared/file", false, 0, 0666, false},
+		{"/plain/file", true, 0022, 0644, false},
+		{"/shared/file", true, 0077, 0660, true},
+		{"/shared/file", false, 0027, 0640, false},
+	}
+	for _, tt := range tests {
+		cr, err := New(WithFileSystem(fsys), WithPOSIXACL(tt.acl), WithUmask(tt.umask)).canCreate(1000, []int{1000}, tt.path)
+		if err != nil {
+			t.Errorf("%s (ACL %v, umask %o): got %v", tt.path, tt.acl, tt.umask, err)
+		} else if cr.Mode != tt.mode || cr.DefaultACL != tt.defaultACL {
+			t.Errorf("%s (ACL %v, umask %o): got mode %o (default ACL %v), want %o (%v)", tt.path, tt.acl, tt.umask, cr.Mode, cr.DefaultACL, tt.mode, tt.defaultACL)
+		}
+	}
+}
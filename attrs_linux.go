@@ -0,0 +1,53 @@
+package access
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// inode flags, see ioctl_iflags(2)
+const (
+	fsImmutableFl = 0x10
+	fsAppendFl    = 0x20
+)
+
+// fsIocGetflags returns the FS_IOC_GETFLAGS ioctl request, _IOR('f', 1, long)
+func fsIocGetflags() uintptr {
+	// _IOC_READ << _IOC_DIRSHIFT
+	dir := uintptr(2 << 30)
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc", "ppc64", "ppc64le", "sparc64":
+		dir = 2 << 29
+	}
+	return dir | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 1
+}
+
+func attrs(path string) (Attrs, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return Attrs{}, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer syscall.Close(fd)
+	return attrsOf(fd, path)
+}
+
+// attrsOf returns the attributes of an open file, path being its path for errors
+func attrsOf(fd int, path string) (Attrs, error) {
+	// the kernel reads and writes an int, regardless of the request size
+	var flags int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), fsIocGetflags(), uintptr(unsafe.Pointer(&flags)))
+	if errno != 0 {
+		if errors.Is(errno, syscall.ENOTTY) || errors.Is(errno, syscall.ENOTSUP) || errors.Is(errno, syscall.EINVAL) {
+			// the filesystem does not support attributes
+			return Attrs{}, nil
+		}
+		return Attrs{}, &os.PathError{Op: "ioctl", Path: path, Err: errno}
+	}
+	return Attrs{
+		Immutable:  flags&fsImmutableFl != 0,
+		AppendOnly: flags&fsAppendFl != 0,
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package access
+
+// reading file attributes is only supported on Linux and BSDs
+func attrs(path string) (Attrs, error) {
+	return Attrs{}, ErrUnsupported
+}
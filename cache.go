@@ -0,0 +1,182 @@
+package access
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache checks whether users have the permissions to access files, caching
+// the group ids of the users across calls.
+//
+// Looking up the groups of a user can be slow on systems with remote user
+// databases (LDAP, SSSD, ...), which a Cache avoids for repeated checks of
+// the same users.
+//
+// The groups are looked up and the checks are made with the options of the
+// Checker the Cache was created from, see Checker.NewCache.
+//
+// The cached groups can go stale if the group membership of a user changes
+// before their entry expires; call Invalidate to drop the entry of a user.
+//
+// A Cache is safe for concurrent use by multiple goroutines.
+type Cache struct {
+	checker *Checker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[int]cacheEntry
+}
+
+type cacheEntry struct {
+	gids    []int
+	expires time.Time
+}
+
+// NewCache creates a Cache whose entries expire after ttl, using the default options.
+func NewCache(ttl time.Duration) *Cache {
+	return defaultChecker.NewCache(ttl)
+}
+
+// NewCache is like the package-level NewCache, using the options of the Checker.
+func (c *Checker) NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		checker: c,
+		ttl:     ttl,
+		entries: make(map[int]cacheEntry),
+	}
+}
+
+// Uid is like the Uid method of the Checker of the Cache, using the cached groups of the user.
+func (c *Cache) Uid(uid int, mode os.FileMode, path string) error {
+	gi, err := c.groupIds(uid)
+	if err != nil {
+		return err
+	}
+	return c.checker.Check(uid, gi, mode, path)
+}
+
+// Invalidate drops the cached groups of a user, if any.
+func (c *Cache) Invalidate(uid int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uid)
+}
+
+func (c *Cache) groupIds(uid int) ([]int, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[uid]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.gids, nil
+	}
+
+	id, err := c.checker.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[uid] = cacheEntry{
+		gids:    id.Gids,
+		expires: now.Add(c.ttl),
+	}
+	c.mu.Unlock()
+	return id.Gids, nil
+}
+
+// decisionCache is a cache of the decisions of the checks of a Checker, see WithDecisionCache
+type decisionCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[decisionKey]*list.Element
+	// decisions, most recently used first
+	lru *list.List
+}
+
+type decisionKey struct {
+	uid  int
+	gids string
+	mode os.FileMode
+	// resolved path
+	path string
+}
+
+type decision struct {
+	key     decisionKey
+	err     *PermissionError
+	expires time.Time
+}
+
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[decisionKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func newDecisionKey(uid int, gids []int, mode os.FileMode, path string) decisionKey {
+	return decisionKey{
+		uid:  uid,
+		gids: fmt.Sprint(gids),
+		mode: mode,
+		path: path,
+	}
+}
+
+// get returns the cached decision for key, if any: a nil error if the access was granted
+func (c *decisionCache) get(key decisionKey, now time.Time) (*PermissionError, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	d := e.Value.(*decision)
+	if !now.Before(d.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	if d.err == nil {
+		return nil, true
+	}
+	// the caller may modify the returned error
+	pe := *d.err
+	pe.Gid = append([]int(nil), pe.Gid...)
+	return &pe, true
+}
+
+// put caches a decision for key: err is nil if the access was granted
+func (c *decisionCache) put(key decisionKey, err *PermissionError, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := &decision{key: key, err: err, expires: now.Add(c.ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = d
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(d)
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*decision).key)
+	}
+}
+
+func (c *decisionCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[decisionKey]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,131 @@
+//go:build !windows
+// +build !windows
+
+package access
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	file, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := os.Getuid()
+
+	c := NewCache(time.Hour)
+	if err := c.Uid(uid, Read, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.entries[uid]; !ok {
+		t.Fatalf("groups of uid %d not cached", uid)
+	}
+
+	// the cached groups are used instead of the real ones
+	c.entries[uid] = cacheEntry{gids: []int{-1}, expires: time.Now().Add(time.Hour)}
+	if gids, err := c.groupIds(uid); err != nil || len(gids) != 1 || gids[0] != -1 {
+		t.Errorf("got groups %v (error %v), want cached groups", gids, err)
+	}
+
+	c.Invalidate(uid)
+	if _, ok := c.entries[uid]; ok {
+		t.Errorf("groups of uid %d still cached after Invalidate", uid)
+	}
+
+	c = NewCache(0)
+	if err := c.Uid(uid, Read, file); err != nil {
+		t.Fatal(err)
+	}
+	c.entries[uid] = cacheEntry{gids: []int{-1}, expires: time.Now()}
+	if gids, err := c.groupIds(uid); err != nil || (len(gids) == 1 && gids[0] == -1) {
+		t.Errorf("got groups %v (error %v), want refreshed groups", gids, err)
+	}
+
+	// the checks use the options of the Checker of the Cache
+	if _, err := os.Lstat("/tmp/alice"); err == nil {
+		t.Skip("/tmp/alice exists")
+	}
+	c = New(WithFileSystem(testFS)).NewCache(time.Hour)
+	if err := c.Uid(uid, Read, "/tmp/alice"); os.IsNotExist(err) {
+		t.Errorf("checker filesystem: got %v, want file of the filesystem of the Checker", err)
+	}
+}
+
+func TestDecisionCache(t *testing.T) {
+	fs := memFS{}
+	for name, f := range testFS {
+		fs[name] = f
+	}
+	fs["/home/alice/secret"] = memFile{mode: 0600}
+	c := New(WithFileSystem(fs), WithDecisionCache(2, time.Hour))
+
+	check := func(name string, uid int, path string, denied bool) {
+		t.Helper()
+		err := c.Check(uid, []int{uid}, Read, path)
+		var pe *PermissionError
+		if denied && !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want PermissionError", name, err)
+		} else if !denied && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	check("allowed", 1000, "/srv/data", false)
+	// the decision is cached for the resolved path: it goes stale when the file changes
+	f := fs["/home/alice/file"]
+	f.mode = 0200
+	fs["/home/alice/file"] = f
+	check("cached allowed", 1000, "/srv/data", false)
+	check("cached allowed by resolved path", 1000, "/home/alice/link", false)
+	// but not when a symlink on the path is replaced
+	fs["/srv/data"] = memFile{mode: os.ModeSymlink | 0777, link: "/home/alice/secret"}
+	check("replaced symlink", 1000, "/srv/data", true)
+	fs["/home/alice/secret"] = memFile{mode: 0644}
+	check("cached denied", 1000, "/srv/data", true)
+
+	// the size is 2: the least recently used decision was evicted
+	check("new", 1000, "/tmp/alice", false)
+	check("evicted", 1000, "/home/alice/file", true)
+	check("kept", 1000, "/tmp/alice", false)
+
+	c.FlushCache()
+	check("flushed", 1000, "/srv/data", false)
+
+	// denials of the resolution are not cached
+	check("denied resolution", 1001, "/home/alice/secret", true)
+	d := fs["/home/alice"]
+	d.mode = os.ModeDir | 0755
+	fs["/home/alice"] = d
+	check("resolution", 1001, "/home/alice/secret", false)
+
+	// missing files are not cached
+	for i := 0; i < 2; i++ {
+		if err := c.Check(1000, []int{1000}, Read, "/srv/hidden"); !os.IsNotExist(err) {
+			t.Errorf("missing: got %v, want not exist", err)
+		}
+	}
+}
+
+func TestDecisionCacheExpiry(t *testing.T) {
+	dc := newDecisionCache(1, time.Minute)
+	key := newDecisionKey(1000, []int{1000}, Read, "/home/alice/file")
+	now := time.Now()
+	dc.put(key, &PermissionError{File: "/home/alice", Gid: []int{1000}}, now)
+	if pe, ok := dc.get(key, now.Add(time.Second)); !ok || pe == nil || pe.File != "/home/alice" {
+		t.Errorf("fresh: got %v, %v, want cached denial", pe, ok)
+	} else {
+		pe.Gid[0] = 0
+	}
+	if pe, ok := dc.get(key, now.Add(time.Second)); !ok || pe == nil || pe.Gid[0] != 1000 {
+		t.Errorf("modified: got %v, %v, want unmodified cached denial", pe, ok)
+	}
+	if _, ok := dc.get(key, now.Add(time.Minute)); ok {
+		t.Errorf("expired: got cached decision")
+	}
+	if dc.lru.Len() != 0 || len(dc.entries) != 0 {
+		t.Errorf("expired: got %d decisions left", dc.lru.Len())
+	}
+}
@@ -0,0 +1,86 @@
+package access
+
+import (
+	"context"
+	"os"
+)
+
+// Capabilities that override permission checks, as bits of a capability set, for use with CheckCaps.
+//
+// The bits match the Linux capability numbers, so that capability sets read from the kernel
+// (for example CapEff in /proc/<pid>/status) can be passed as is.
+const (
+	// CapDACOverride (CAP_DAC_OVERRIDE) bypasses read, write and execute permission checks,
+	// except that a file can only be executed if at least one of its execute bits is set.
+	CapDACOverride uint64 = 1 << 1
+	// CapDACReadSearch (CAP_DAC_READ_SEARCH) bypasses file read permission checks, and directory
+	// read and search (execute) permission checks.
+	CapDACReadSearch uint64 = 1 << 2
+)
+
+// capabilities of root, when not specified explicitly
+const rootCaps = CapDACOverride | CapDACReadSearch
+
+// CheckCaps checks whether a user with a set of capabilities has the permissions to access a file.
+//
+// It behaves like Check, except that the permission checks bypassed by caps are skipped, and
+// that uid 0 is not treated specially: its capabilities must be set in caps. This is useful to
+// predict the access of processes that run with specific capabilities, for example in containers.
+//
+// - uid is the *nix uid of the user
+//
+// - gids are the *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+//
+// - caps is the set of capabilities of the user, for example CapDACOverride and/or CapDACReadSearch (other bits are ignored)
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func CheckCaps(uid int, gids []int, caps uint64, mode os.FileMode, path string) error {
+	return defaultChecker.CheckCaps(uid, gids, caps, mode, path)
+}
+
+// CheckCaps is like the package-level CheckCaps, using the options of the Checker.
+func (c *Checker) CheckCaps(uid int, gids []int, caps uint64, mode os.FileMode, path string) error {
+	return c.checkCaps(uid, gids, caps, mode, path)
+}
+
+func (c *Checker) checkCaps(uid int, gids []int, caps uint64, mode os.FileMode, path string) error {
+	w := c.newWalk(context.Background(), uid, gids)
+	w.caps = caps
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	return w.checkPath(mode, dest)
+}
+
+// override returns the permissions of mode that must be checked on a file with mode fm,
+// after removing those bypassed by the capabilities of the user
+func (w *walk) override(fm os.FileMode, mode os.FileMode) os.FileMode {
+	if w.caps&CapDACReadSearch != 0 {
+		if fm.IsDir() {
+			mode &^= Read | Execute
+		} else {
+			mode &^= Read
+		}
+	}
+	if w.caps&CapDACOverride != 0 {
+		if fm.IsDir() {
+			mode = 0
+		} else {
+			mode &^= Read | Write
+			// a file can only be executed if at least one of its execute bits is set
+			if fm&0111 != 0 {
+				mode &^= Execute
+			}
+		}
+	}
+	return mode
+}
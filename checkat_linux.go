@@ -0,0 +1,224 @@
+package access
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CheckAt checks whether a user identified by its uid and group ids has the permissions to access a file relative to a directory file descriptor.
+//
+// It behaves like Check, except that name is resolved beneath the directory referred to by dirfd,
+// like openat2(2) with RESOLVE_IN_ROOT: dirfd is treated as the root directory, so absolute names
+// and symlinks are resolved relative to it, and .. cannot escape it. The symlinks are resolved by
+// the check itself, and each file is looked up from dirfd one component at a time, with openat(2)
+// and O_NOFOLLOW, then fstatat(2) or readlinkat(2), so that no lookup follows a symlink or leaves
+// the directory, even if a directory below it is replaced during the check.
+//
+// The permissions of the directory itself and of the files below it are checked, but not those of
+// the ancestors of the directory: the file descriptor only proves that the calling process could
+// reach the directory, not that the user can. The caller must check that the user can search the
+// ancestors of the directory, for example with Check on its path when opening it, otherwise a
+// process with more privileges than the user, such as root, reports accesses to files the user
+// cannot reach.
+//
+// This reduces the races between the check and a subsequent openat(2) relative to the same file
+// descriptor: an attacker cannot swap an ancestor of the directory for a symlink in between. The
+// files below the directory can still change during the check, or between the check and their
+// opening, so the check is not free of races: each file is observed when it is looked up.
+//
+// CheckAt is only supported on Linux; on other platforms, ErrUnsupported is returned.
+func CheckAt(dirfd int, uid int, gids []int, mode os.FileMode, name string) error {
+	return defaultChecker.CheckAt(dirfd, uid, gids, mode, name)
+}
+
+// CheckAt is like the package-level CheckAt, using the options of the Checker, except for its
+// FileSystem and its decision cache (see WithDecisionCache), which are keyed by the paths of the
+// FileSystem. The paths of the options, for example of WithTrustedRoot, are relative to dirfd,
+// like name.
+func (c *Checker) CheckAt(dirfd int, uid int, gids []int, mode os.FileMode, name string) error {
+	cc := *c
+	cc.fs = dirfdFileSystem{fd: dirfd}
+	cc.decisions = nil
+	return cc.Check(uid, gids, mode, "/"+name)
+}
+
+// dirfdFileSystem is a FileSystem whose root directory is a directory file descriptor
+type dirfdFileSystem struct {
+	fd int
+}
+
+// openParent opens the directory containing name, an absolute path relative to the directory,
+// one component at a time without following symlinks, and returns it with the last component
+// of name ("." if name is the directory itself); the returned file descriptor must be closed
+func (d dirfdFileSystem) openParent(name string) (int, string, error) {
+	dir, base := filepath.Split(filepath.Clean(name))
+	if base == "" {
+		base = "."
+	}
+	const flags = unix.O_PATH | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	fd, err := unix.Openat(d.fd, ".", flags, 0)
+	if err != nil {
+		return -1, "", &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	for _, c := range strings.Split(dir, "/") {
+		if c == "" {
+			continue
+		}
+		nfd, err := unix.Openat(fd, c, flags, 0)
+		unix.Close(fd)
+		if err != nil {
+			return -1, "", &os.PathError{Op: "openat", Path: name, Err: err}
+		}
+		fd = nfd
+	}
+	return fd, base, nil
+}
+
+func (d dirfdFileSystem) Lstat(name string) (os.FileInfo, error) {
+	fd, base, err := d.openParent(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstatat(fd, base, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, &os.PathError{Op: "fstatat", Path: name, Err: err}
+	}
+	return &dirfdFileInfo{
+		name: filepath.Base(name),
+		st:   st,
+	}, nil
+}
+
+func (d dirfdFileSystem) Readlink(name string) (string, error) {
+	fd, base, err := d.openParent(name)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+	for n := 128; ; n *= 2 {
+		b := make([]byte, n)
+		l, err := unix.Readlinkat(fd, base, b)
+		if err != nil {
+			return "", &os.PathError{Op: "readlinkat", Path: name, Err: err}
+		}
+		if l < n {
+			return string(b[:l]), nil
+		}
+	}
+}
+
+// openFile opens name, an absolute path relative to the directory, without following symlinks
+// nor leaving the directory, with flags added to O_NOFOLLOW and O_CLOEXEC; the returned file
+// descriptor must be closed
+func (d dirfdFileSystem) openFile(name string, flags int) (int, error) {
+	dir, base, err := d.openParent(name)
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(dir)
+	fd, err := unix.Openat(dir, base, flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	return fd, nil
+}
+
+// procPath returns the path of an open file descriptor in /proc, which leads to the file itself
+func procPath(fd int) string {
+	return "/proc/self/fd/" + strconv.Itoa(fd)
+}
+
+func (d dirfdFileSystem) Getxattr(name string, attr string) ([]byte, error) {
+	fd, err := d.openFile(name, unix.O_PATH)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	return getxattr(procPath(fd), attr)
+}
+
+func (d dirfdFileSystem) Statfs(name string) (FSInfo, error) {
+	fd, err := d.openFile(name, unix.O_PATH)
+	if err != nil {
+		return FSInfo{}, err
+	}
+	defer unix.Close(fd)
+	return statfs(procPath(fd))
+}
+
+func (d dirfdFileSystem) Attrs(name string) (Attrs, error) {
+	// like attrs, the ioctl requires a file opened for reading
+	fd, err := d.openFile(name, unix.O_RDONLY|unix.O_NONBLOCK)
+	if err != nil {
+		return Attrs{}, err
+	}
+	defer unix.Close(fd)
+	return attrsOf(fd, name)
+}
+
+type dirfdFileInfo struct {
+	name string
+	st   unix.Stat_t
+}
+
+func (fi *dirfdFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *dirfdFileInfo) Size() int64 {
+	return fi.st.Size
+}
+
+func (fi *dirfdFileInfo) Mode() os.FileMode {
+	m := os.FileMode(fi.st.Mode & 0777)
+	switch fi.st.Mode & unix.S_IFMT {
+	case unix.S_IFBLK:
+		m |= os.ModeDevice
+	case unix.S_IFCHR:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case unix.S_IFDIR:
+		m |= os.ModeDir
+	case unix.S_IFIFO:
+		m |= os.ModeNamedPipe
+	case unix.S_IFLNK:
+		m |= os.ModeSymlink
+	case unix.S_IFSOCK:
+		m |= os.ModeSocket
+	}
+	if fi.st.Mode&unix.S_ISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if fi.st.Mode&unix.S_ISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if fi.st.Mode&unix.S_ISVTX != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+func (fi *dirfdFileInfo) ModTime() time.Time {
+	return time.Unix(fi.st.Mtim.Unix())
+}
+
+func (fi *dirfdFileInfo) IsDir() bool {
+	return fi.Mode().IsDir()
+}
+
+func (fi *dirfdFileInfo) Sys() interface{} {
+	return &syscall.Stat_t{
+		Dev:   fi.st.Dev,
+		Ino:   fi.st.Ino,
+		Nlink: fi.st.Nlink,
+		Mode:  fi.st.Mode,
+		Uid:   fi.st.Uid,
+		Gid:   fi.st.Gid,
+	}
+}
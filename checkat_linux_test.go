@@ -0,0 +1,100 @@
+package access
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDirfdFileSystemNoFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a directory replaced by a symlink leading outside the directory
+	if err := os.Symlink(os.TempDir(), filepath.Join(dir, "swapped")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fsys := dirfdFileSystem{fd: int(f.Fd())}
+
+	if fi, err := fsys.Lstat("/sub/file"); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("file: got %v, want regular file", err)
+	}
+	if fi, err := fsys.Lstat("/"); err != nil || !fi.IsDir() {
+		t.Errorf("directory itself: got %v, want directory", err)
+	}
+	if fi, err := fsys.Lstat("/swapped"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink: got %v, want symlink", err)
+	}
+	if _, err := fsys.Lstat("/swapped/" + filepath.Base(dir)); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("through symlink: got %v, want ENOTDIR", err)
+	}
+	if _, err := fsys.Readlink("/swapped/x"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("readlink through symlink: got %v, want ENOTDIR", err)
+	}
+	if link, err := fsys.Readlink("/swapped"); err != nil || link != os.TempDir() {
+		t.Errorf("readlink: got %q (error %v), want %q", link, err, os.TempDir())
+	}
+}
+
+func TestCheckerCheckAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	uid, gid := os.Getuid(), os.Getgid()
+
+	if err := CheckAt(fd, uid, []int{gid}, Read, "link"); err != nil {
+		t.Errorf("default options: %v", err)
+	}
+	// the options of the Checker apply
+	var se *SymlinkError
+	if err := New(WithDisallowSymlinks(true)).CheckAt(fd, uid, []int{gid}, Read, "link"); !errors.As(err, &se) {
+		t.Errorf("disallowed symlinks: got %v, want SymlinkError", err)
+	} else if se.Path != "/link" {
+		t.Errorf("disallowed symlinks: got SymlinkError on %q, want /link", se.Path)
+	}
+	// including those read from the filesystem
+	c := New(WithPOSIXACL(true), WithRejectSpecialFS(true), WithReadOnlyCheck(true), WithAttrCheck(true))
+	if err := c.CheckAt(fd, uid, []int{gid}, Read|Write, "file"); err != nil {
+		t.Errorf("filesystem options: %v", err)
+	}
+
+	fsys := dirfdFileSystem{fd: fd}
+	if info, err := fsys.Statfs("/file"); err != nil || info.Type == "" {
+		t.Errorf("statfs: got %+v (error %v), want filesystem type", info, err)
+	}
+	if _, err := fsys.Attrs("/link"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("attrs of symlink: got %v, want ELOOP", err)
+	}
+}
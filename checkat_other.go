@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package access
+
+import (
+	"os"
+)
+
+// CheckAt checks whether a user identified by its uid and group ids has the permissions to access a file relative to a directory file descriptor.
+//
+// CheckAt is only supported on Linux; on other platforms, ErrUnsupported is returned.
+func CheckAt(dirfd int, uid int, gids []int, mode os.FileMode, name string) error {
+	return defaultChecker.CheckAt(dirfd, uid, gids, mode, name)
+}
+
+// CheckAt is like the package-level CheckAt, using the options of the Checker.
+func (c *Checker) CheckAt(dirfd int, uid int, gids []int, mode os.FileMode, name string) error {
+	return ErrUnsupported
+}
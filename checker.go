@@ -0,0 +1,516 @@
+package access
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// DefaultMaxSymlinkDepth is the default maximum number of symlinks resolved when checking a path.
+const DefaultMaxSymlinkDepth = 255
+
+// FileSystem is a filesystem from which permissions and symlinks are read.
+//
+// The FileInfo returned by Lstat must return a *syscall.Stat_t from its Sys method.
+type FileSystem interface {
+	// Lstat returns the FileInfo of a file, without following symlinks, like os.Lstat.
+	Lstat(name string) (os.FileInfo, error)
+	// Readlink returns the target of a symlink, like os.Readlink.
+	Readlink(name string) (string, error)
+}
+
+// XattrFileSystem is a FileSystem that can read extended attributes, which are
+// used to read ACLs.
+type XattrFileSystem interface {
+	FileSystem
+	// Getxattr returns the value of an extended attribute of a file, or nil if the file
+	// does not have the attribute.
+	Getxattr(name string, attr string) ([]byte, error)
+}
+
+// FSInfo is information about a mounted filesystem.
+type FSInfo struct {
+	// whether the filesystem is mounted read-only
+	ReadOnly bool
+	// type of the filesystem, for example "ext4" or "proc", or empty if unknown; on Linux, the
+	// types not known to this package are formatted as their f_type magic number, like "0xef53"
+	Type string
+}
+
+// StatfsFileSystem is a FileSystem that can read information about the mounted
+// filesystems of files.
+type StatfsFileSystem interface {
+	FileSystem
+	// Statfs returns information about the mounted filesystem containing a file, like statfs(2).
+	Statfs(name string) (FSInfo, error)
+}
+
+// Attrs are the attributes of a file, see chattr(1).
+type Attrs struct {
+	// whether the file is immutable (chattr +i)
+	Immutable bool
+	// whether the file is append-only (chattr +a)
+	AppendOnly bool
+}
+
+// AttrFileSystem is a FileSystem that can read the attributes of files.
+type AttrFileSystem interface {
+	FileSystem
+	// Attrs returns the attributes of a file, without following symlinks.
+	Attrs(name string) (Attrs, error)
+}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFileSystem) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFileSystem) Getxattr(name string, attr string) ([]byte, error) {
+	return getxattr(name, attr)
+}
+
+func (osFileSystem) Statfs(name string) (FSInfo, error) {
+	return statfs(name)
+}
+
+func (osFileSystem) Attrs(name string) (Attrs, error) {
+	return attrs(name)
+}
+
+// Checker checks whether users have the permissions to access files, with
+// custom options.
+//
+// The package-level functions use a Checker with the default options: most of
+// them are wrappers around the method of the same name of a default Checker.
+//
+// A Checker must be created with New. Its options are fixed by New, and each
+// check uses its own state. The only state shared by the checks is the cache
+// of decisions enabled by WithDecisionCache, which is guarded by a mutex. A
+// Checker can therefore be created once and used concurrently by multiple
+// goroutines, as long as its FileSystem is itself safe for concurrent use (the
+// default one is).
+type Checker struct {
+	maxSymlinkDepth      int
+	fs                   FileSystem
+	posixACL             bool
+	nfs4ACL              bool
+	readOnlyCheck        bool
+	attrCheck            bool
+	noSymlinks           bool
+	stayOnDevice         bool
+	maxSymlinkEscapes    int
+	primaryGroupOnly     bool
+	umask                os.FileMode
+	rejectMultiplyLinked bool
+	rejectSpecialFS      bool
+	trustedRoot          string
+	allowedTargets       []string
+	getgrouplist         bool
+	consistencyRetries   int
+	maxGroups            int
+	decisions            *decisionCache
+	denyWorldWritable    bool
+}
+
+// Option is an option of a Checker, to be passed to New.
+type Option func(c *Checker)
+
+// WithMaxSymlinkDepth sets the maximum number of symlinks resolved when checking a path,
+// after which a TooManyLinksError is returned.
+//
+// Defaults to DefaultMaxSymlinkDepth.
+func WithMaxSymlinkDepth(depth int) Option {
+	return func(c *Checker) {
+		c.maxSymlinkDepth = depth
+	}
+}
+
+// WithFileSystem sets the filesystem from which permissions and symlinks are read.
+//
+// Defaults to the filesystem of the OS.
+func WithFileSystem(fsys FileSystem) Option {
+	return func(c *Checker) {
+		c.fs = fsys
+	}
+}
+
+// WithPOSIXACL sets whether POSIX access ACLs are honored.
+//
+// When enabled, the system.posix_acl_access extended attribute of a file is read if its
+// permission bits deny access, or if the user does not own it, and if the file has an ACL,
+// access is granted only if its ACL grants it. As with the kernel, the permissions of named
+// users, named groups and the owning group are restricted by the mask entry of the ACL, which
+// the group bits of the file reflect. This requires an additional system call for each such
+// file, and is only supported on Linux, or with a custom XattrFileSystem.
+//
+// Defaults to false.
+func WithPOSIXACL(enabled bool) Option {
+	return func(c *Checker) {
+		c.posixACL = enabled
+	}
+}
+
+// WithNFSv4ACL sets whether NFSv4 ACLs are honored, for example on NFSv4 or ZFS shares.
+//
+// When enabled, if the permission bits of a file deny access, its system.nfs4_acl extended
+// attribute is read, and access is granted if its ACEs, processed in order, allow the requested
+// access before any matching ACE denies it. Only the OWNER@, GROUP@ and EVERYONE@ principals
+// and numeric ids are supported. This requires an additional system call and the parsing of
+// the ACL for each denied file, and is only supported on Linux, or with a custom XattrFileSystem.
+//
+// Defaults to false.
+func WithNFSv4ACL(enabled bool) Option {
+	return func(c *Checker) {
+		c.nfs4ACL = enabled
+	}
+}
+
+// WithReadOnlyCheck sets whether read-only mounts are detected.
+//
+// When enabled, if Write is requested on a file, the mount flags of its filesystem are read,
+// and ErrReadOnlyFS is returned if it is mounted read-only. As with the kernel, FIFOs, sockets
+// and device nodes can be written on a read-only mount, and are not checked. This requires an
+// additional system call, and is only supported on Linux, macOS and FreeBSD, or with a custom
+// StatfsFileSystem.
+//
+// Defaults to false.
+func WithReadOnlyCheck(enabled bool) Option {
+	return func(c *Checker) {
+		c.readOnlyCheck = enabled
+	}
+}
+
+// WithAttrCheck sets whether the immutable and append-only attributes of files are honored.
+//
+// When enabled, if Write is requested on a regular file or directory, its attributes are read,
+// and ErrImmutable or ErrAppendOnly is returned if it is immutable or append-only. This requires
+// additional system calls, and is only supported on Linux (on filesystems supporting the
+// FS_IOC_GETFLAGS ioctl) and BSDs (using st_flags), or with a custom AttrFileSystem.
+//
+// Defaults to false.
+func WithAttrCheck(enabled bool) Option {
+	return func(c *Checker) {
+		c.attrCheck = enabled
+	}
+}
+
+// WithRejectMultiplyLinkedWrites sets whether writes to files with several hard links are rejected.
+//
+// When enabled, if Write is requested on a regular file that has more than one hard link
+// (st_nlink > 1), ErrMultiplyLinked is returned, since modifying it would also modify the file
+// as seen through its other names. Writing to a FIFO, socket or device node does not modify it,
+// so these are not rejected.
+//
+// Defaults to false.
+func WithRejectMultiplyLinkedWrites(enabled bool) Option {
+	return func(c *Checker) {
+		c.rejectMultiplyLinked = enabled
+	}
+}
+
+// WithRejectSpecialFS sets whether files of pseudo-filesystems are rejected.
+//
+// When enabled, after a path is resolved, the type of the filesystem of the resolved file is
+// read, and a SpecialFSError is returned if it is a pseudo-filesystem such as proc, sysfs,
+// devpts, cgroup or devfs, whose files do not behave like regular files: for example, the
+// symlinks of /proc/<pid>/root lead into the filesystem of another process. This requires an
+// additional system call, and is only supported on Linux, macOS and FreeBSD, or with a custom
+// StatfsFileSystem.
+//
+// Defaults to false.
+func WithRejectSpecialFS(enabled bool) Option {
+	return func(c *Checker) {
+		c.rejectSpecialFS = enabled
+	}
+}
+
+// WithTrustedRoot sets a directory whose reachability was already verified, so that its search
+// permission and that of its ancestors are not checked again.
+//
+// When set, the search permission of the directories traversed during a check is not checked on
+// root and its ancestors, which saves reading them on every check, for example in a file server
+// whose serving root is fixed and was verified once. The symlinks are still resolved, and the
+// files below root, or outside it, are checked normally, as is the requested permission on the
+// requested file itself. This is only correct if every user checked can search root and its
+// ancestors, and if their permissions do not change.
+//
+// root must be an absolute path, with all its symlinks already resolved: New panics otherwise.
+//
+// Defaults to empty, in which case all directories are checked.
+func WithTrustedRoot(root string) Option {
+	return func(c *Checker) {
+		if root != "" {
+			if !filepath.IsAbs(root) {
+				panic("access: trusted root is not an absolute path: " + root)
+			}
+			root = filepath.Clean(root)
+		}
+		c.trustedRoot = root
+	}
+}
+
+// WithAllowedSymlinkTargets sets the directories that symlinks are allowed to point into.
+//
+// When set, a SymlinkTargetError is returned as soon as a symlink followed while resolving a
+// path has a target that is not one of targets or within one of them. The target is made
+// absolute and cleaned lexically, as written in the symlink, before being compared; the
+// symlinks it contains are checked in turn when they are followed.
+//
+// targets must be absolute paths, with all their symlinks already resolved.
+//
+// Defaults to empty, in which case symlinks may point anywhere.
+func WithAllowedSymlinkTargets(targets []string) Option {
+	return func(c *Checker) {
+		c.allowedTargets = nil
+		for _, t := range targets {
+			c.allowedTargets = append(c.allowedTargets, filepath.Clean(t))
+		}
+	}
+}
+
+// WithGetgrouplist sets whether the groups of users looked up by uid are resolved with
+// getgrouplist(3) from the C library.
+//
+// os/user already uses getgrouplist when it is built with cgo. When it is not, for example with
+// the osusergo or netgo build tags or with CGO_ENABLED=0, it only reads /etc/group, and misses
+// the groups provided by other NSS sources, such as nsswitch compat entries, netgroups, or LDAP.
+// This option calls getgrouplist directly, so that all the groups known to the system are
+// considered, whatever the os/user implementation. It requires cgo, on Linux, macOS and the
+// BSDs: otherwise, looking up the groups of a user returns a GroupLookupError wrapping
+// ErrUnsupported.
+//
+// Identities passed explicitly, for example to CheckIdentity, are not affected.
+//
+// Defaults to false.
+func WithGetgrouplist(enabled bool) Option {
+	return func(c *Checker) {
+		c.getgrouplist = enabled
+	}
+}
+
+// WithConsistencyRetries sets the number of times a check is retried when the file it checked
+// changed while it was being checked.
+//
+// Resolving a path and checking its permissions takes several system calls, between which
+// another process can replace a file, so that the result mixes the states before and after the
+// change. When retries is positive, after checking a path with Uid, Check and their variants,
+// the resolved file is stat'ed again, and if its device and inode numbers differ from those
+// seen by the check, the check is run again, up to retries more times, after which an error
+// matching ErrRaced is returned. This is only a best-effort mitigation: the file can still
+// change right after the check, and callers that can should check an open file instead, see
+// CheckFile.
+//
+// Defaults to 0, in which case each path is checked in a single pass.
+func WithConsistencyRetries(retries int) Option {
+	return func(c *Checker) {
+		c.consistencyRetries = retries
+	}
+}
+
+// WithDenyWorldWritableAncestors sets whether paths with world-writable ancestors are rejected.
+//
+// When enabled, while resolving a path, an UnsafeAncestorError is returned as soon as a directory
+// containing one of its components, including the components of the symlink targets, is writable
+// by all users (its other write bit is set) without being sticky, even if the user can search it:
+// any user could then replace the file, or the directories below, with their own. This is a
+// hardening check rather than a permission check. Together with CheckOwnership or CheckSafeDir,
+// it can assert that a whole path is trusted.
+//
+// Defaults to false.
+func WithDenyWorldWritableAncestors(enabled bool) Option {
+	return func(c *Checker) {
+		c.denyWorldWritable = enabled
+	}
+}
+
+// WithDecisionCache sets whether the decisions of the checks are cached, and how many.
+//
+// When size and ttl are positive, the decisions of Uid, Check and their variants on the resolved
+// path, whether the access is granted or denied with a PermissionError, are cached for ttl. The
+// path is still resolved on every check, so that a replaced symlink is always followed to its new
+// target, but a check resolving to the same file then returns the cached decision without checking
+// the file and its ancestors again. The decisions are keyed by the uid and gids of the user, the
+// requested mode, and the resolved path. Only the size most recently used decisions are kept.
+// Errors of the resolution, including a PermissionError for a directory that cannot be searched,
+// and other errors, for example for a missing file, are not cached.
+//
+// A cached decision goes stale as soon as the permissions or the owners of the resolved file or
+// its ancestors change: until it expires, the previous decision is returned, which can grant an
+// access that is now denied. Only use this option with a short ttl, on files whose
+// permissions rarely change, and call FlushCache after changing them.
+//
+// Defaults to 0 and 0, in which case no decision is cached.
+func WithDecisionCache(size int, ttl time.Duration) Option {
+	return func(c *Checker) {
+		c.decisions = nil
+		if size > 0 && ttl > 0 {
+			c.decisions = newDecisionCache(size, ttl)
+		}
+	}
+}
+
+// FlushCache drops all the decisions cached by the Checker, see WithDecisionCache.
+func (c *Checker) FlushCache() {
+	if c.decisions != nil {
+		c.decisions.flush()
+	}
+}
+
+// allowedTarget returns whether a symlink may point to target, see WithAllowedSymlinkTargets
+func (c *Checker) allowedTarget(target string) bool {
+	for _, t := range c.allowedTargets {
+		if within(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDisallowSymlinks sets whether paths containing symlinks are rejected.
+//
+// When enabled, a SymlinkError is returned as soon as any component of a path is a symlink,
+// before resolving it, including the final component of a path that is not followed. This
+// prevents symlinks from being used to escape an intended directory.
+//
+// Defaults to false.
+func WithDisallowSymlinks(disallow bool) Option {
+	return func(c *Checker) {
+		c.noSymlinks = disallow
+	}
+}
+
+// WithStayOnDevice sets whether paths must stay on the filesystem of the root directory.
+//
+// When enabled, a CrossDeviceError is returned as soon as any component of a path, including
+// the targets of symlinks, is on a different device (st_dev) than the root directory, for
+// example on another filesystem or a bind mount of one.
+//
+// Defaults to false.
+func WithStayOnDevice(enabled bool) Option {
+	return func(c *Checker) {
+		c.stayOnDevice = enabled
+	}
+}
+
+// WithMaxSymlinkEscapes sets the maximum number of symlinks leading outside the directory
+// of the requested file when checking a path, after which a SymlinkEscapeError is returned.
+//
+// A symlink escapes if it is inside the directory containing the requested file (or one of
+// its subdirectories) and its target is outside it. The symlinks outside the directory, for
+// example in its ancestors, are not counted, but a resolution that leads back inside the
+// directory and out again escapes twice. This is a softer version of the confinement of
+// CheckWithin, limiting lateral movements through symlinks.
+//
+// A negative max disables the limit. Defaults to -1.
+func WithMaxSymlinkEscapes(max int) Option {
+	return func(c *Checker) {
+		c.maxSymlinkEscapes = max
+	}
+}
+
+// WithPrimaryGroupOnly sets whether only the primary group of users grants them the
+// permissions of the group class.
+//
+// By default, the permissions of the group of a file apply to a user if the group is any of
+// the groups of the user, primary or supplementary, like Linux and modern Unixes do. When
+// enabled, only the primary group of the user (the first element of its group ids) is
+// considered, like some older Unixes do: a user whose group matches the file only through a
+// supplementary group gets the permissions of the other class. This applies to ACL entries
+// too, and the Gid of the returned PermissionErrors only contains the primary group.
+//
+// Defaults to false.
+func WithPrimaryGroupOnly(enabled bool) Option {
+	return func(c *Checker) {
+		c.primaryGroupOnly = enabled
+	}
+}
+
+// WithMaxGroups sets the maximum number of supplementary groups of users that are considered.
+//
+// Some servers only receive a limited number of groups from their clients, for example NFS with
+// AUTH_SYS authentication sends the primary group and at most 16 supplementary groups, so that a
+// user in more groups can be denied access through a group that the server never sees. When max
+// is non-negative, only the primary group of the user (the first element of its group ids) and
+// its first max supplementary groups are considered, to predict the permissions on such a
+// server. The groups are taken in the order of the group ids passed to the check, or for users
+// looked up by uid, in the order returned by the user database (see IdentityForUid), which is
+// assumed to be the order in which the client sends them. This applies to ACL entries too, and
+// the Gid of the returned PermissionErrors only contains the considered groups.
+//
+// A negative max disables the limit. Defaults to -1.
+func WithMaxGroups(max int) Option {
+	return func(c *Checker) {
+		c.maxGroups = max
+	}
+}
+
+// WithUmask sets the umask of the process creating files, used by CanCreate to predict the
+// permissions of created files (see Creation and EffectiveCreateMode).
+//
+// Defaults to 0, in which case the predicted permissions are those before the umask of the
+// creating process applies.
+func WithUmask(umask os.FileMode) Option {
+	return func(c *Checker) {
+		c.umask = umask
+	}
+}
+
+// New creates a Checker with the specified options.
+func New(opts ...Option) *Checker {
+	c := &Checker{
+		maxSymlinkDepth:   DefaultMaxSymlinkDepth,
+		fs:                osFileSystem{},
+		maxSymlinkEscapes: -1,
+		maxGroups:         -1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+var defaultChecker = New()
+
+// Uid is like the package-level Uid, using the options of the Checker.
+func (c *Checker) Uid(uid int, mode os.FileMode, path string) error {
+	return c.UidContext(context.Background(), uid, mode, path)
+}
+
+// UidContext is like the package-level UidContext, using the options of the Checker.
+func (c *Checker) UidContext(ctx context.Context, uid int, mode os.FileMode, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.check(ctx, id.Uid, id.Gids, mode, path, true)
+}
+
+// Username is like the package-level Username, using the options of the Checker.
+func (c *Checker) Username(username string, mode os.FileMode, path string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	return c.User(u, mode, path)
+}
+
+// User is like the package-level User, using the options of the Checker.
+func (c *Checker) User(u *user.User, mode os.FileMode, path string) error {
+	id, err := c.identityOf(u)
+	if err != nil {
+		return err
+	}
+	return c.check(context.Background(), id.Uid, id.Gids, mode, path, true)
+}
+
+// Check is like the package-level Check, using the options of the Checker.
+func (c *Checker) Check(uid int, gids []int, mode os.FileMode, path string) error {
+	return c.check(context.Background(), uid, gids, mode, path, true)
+}
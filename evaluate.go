@@ -0,0 +1,371 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Result is the outcome of a permission check, as returned by Evaluate.
+type Result struct {
+	// whether the user has the requested access to the file
+	Allowed bool
+	// path of the file after resolving all symlinks, or empty if it could not be resolved
+	ResolvedPath string
+	// path of the file/folder on which the permission was denied, or empty if Allowed
+	BlockedBy string
+	// permission class that granted the requested access to the file: "owner", "group", "other",
+	// "root" if the permission checks were bypassed, or "acl" if the access was granted by a POSIX ACL
+	// entry; empty if not Allowed
+	GrantedVia string
+	// gid of the group of the user that granted the requested access, if GrantedVia is "group", or -1;
+	// it can be compared with the primary gid of the user to tell a primary from a supplementary group
+	GrantedGid int
+	// permissions that were missing on BlockedBy, or 0 if Allowed
+	MissingMode os.FileMode
+	// device (st_dev) and inode (st_ino) numbers of ResolvedPath, or 0 if not Allowed
+	Dev uint64
+	Ino uint64
+	// number of hard links (st_nlink) of ResolvedPath, or 0 if not Allowed
+	Nlink uint64
+	// type bits of the mode of ResolvedPath (see os.ModeType), for example os.ModeDir, or
+	// os.ModeDevice|os.ModeCharDevice for a character device; 0 for a regular file, or if not Allowed
+	Type os.FileMode
+	// number of symlinks followed while resolving the path, and number of path components walked,
+	// including the components of the symlink targets; for observability of the cost of the check
+	LinksWalked int
+	Components  int
+}
+
+// Evaluate checks whether a user identified by its uid has the permissions to access a file,
+// and returns the outcome as a Result, for example to serialize it.
+//
+// Unlike Uid, a denial is not an error: it is reported in the Result.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns the outcome of the check
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func Evaluate(uid int, mode os.FileMode, path string) (Result, error) {
+	return defaultChecker.Evaluate(uid, mode, path)
+}
+
+// Evaluate is like the package-level Evaluate, using the options of the Checker.
+func (c *Checker) Evaluate(uid int, mode os.FileMode, path string) (Result, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return Result{}, err
+	}
+	return c.evaluate(id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) evaluate(uid int, gids []int, mode os.FileMode, path string) (Result, error) {
+	if err := checkMode(mode); err != nil {
+		return Result{}, err
+	}
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, true)
+	if err == nil {
+		err = w.checkPath(mode, dest)
+	}
+	var pe *PermissionError
+	if errors.As(err, &pe) {
+		return Result{
+			ResolvedPath: pe.ResolvedPath,
+			BlockedBy:    pe.File,
+			GrantedGid:   -1,
+			MissingMode:  pe.MissingMode,
+			LinksWalked:  w.linksWalked,
+			Components:   w.components,
+		}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	fi, err := w.lstat(dest)
+	if err != nil {
+		return Result{}, err
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return Result{}, err
+	}
+	r := Result{
+		Allowed:      true,
+		ResolvedPath: dest,
+		GrantedVia:   w.grantedVia(fi.Mode(), mode, st.uid, st.gid),
+		GrantedGid:   -1,
+		Dev:          st.dev,
+		Ino:          st.ino,
+		Nlink:        st.nlink,
+		Type:         fi.Mode().Type(),
+		LinksWalked:  w.linksWalked,
+		Components:   w.components,
+	}
+	if r.GrantedVia == "group" {
+		r.GrantedGid = st.gid
+	}
+	return r, nil
+}
+
+// EvaluateSource checks whether a user identified by its uid has the permissions to access a
+// file, and returns the source of the access to the file, for example for audit logs.
+//
+// The source is the GrantedVia of the Result Evaluate would return: "root" if the permission
+// checks were bypassed, "owner", "group" or "other" for the permission class that granted the
+// access, or "acl" if it was granted by an ACL entry. It only describes the decision on the file
+// itself, not on its ancestors.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns the source of the access, or an empty string if the error is non-nil
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func EvaluateSource(uid int, mode os.FileMode, path string) (string, error) {
+	return defaultChecker.EvaluateSource(uid, mode, path)
+}
+
+// EvaluateSource is like the package-level EvaluateSource, using the options of the Checker.
+func (c *Checker) EvaluateSource(uid int, mode os.FileMode, path string) (string, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return "", err
+	}
+	return c.evaluateSource(id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) evaluateSource(uid int, gids []int, mode os.FileMode, path string) (string, error) {
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return "", err
+	}
+	if err := w.checkPath(mode, dest); err != nil {
+		return "", err
+	}
+	fi, err := w.lstat(dest)
+	if err != nil {
+		return "", err
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return "", err
+	}
+	return w.grantedVia(fi.Mode(), mode, st.uid, st.gid), nil
+}
+
+// EvaluateRootBypass checks whether a user identified by its uid has the permissions to access a
+// file, and whether the access is only granted because the user is root, for example to find the
+// files that are only reachable thanks to privilege.
+//
+// Root (uid 0) bypasses the permission bits through its capabilities (see CheckCaps): the file is
+// checked like Uid would, then, if uid is 0 and the access is granted, checked again without the
+// capabilities of root, as for any other user owning the same files.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns whether the access would be denied with a PermissionError without the capabilities of root; always false if uid is not 0
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func EvaluateRootBypass(uid int, mode os.FileMode, path string) (usedRoot bool, err error) {
+	return defaultChecker.EvaluateRootBypass(uid, mode, path)
+}
+
+// EvaluateRootBypass is like the package-level EvaluateRootBypass, using the options of the Checker.
+func (c *Checker) EvaluateRootBypass(uid int, mode os.FileMode, path string) (usedRoot bool, err error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return false, err
+	}
+	return c.evaluateRootBypass(id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) evaluateRootBypass(uid int, gids []int, mode os.FileMode, path string) (bool, error) {
+	if err := c.check(context.Background(), uid, gids, mode, path, true); err != nil {
+		return false, err
+	}
+	if uid != 0 {
+		return false, nil
+	}
+	err := c.checkCaps(uid, gids, 0, mode, path)
+	var pe *PermissionError
+	if errors.As(err, &pe) {
+		return true, nil
+	}
+	return false, err
+}
+
+// EvaluateTree checks whether a user identified by its uid has the permissions to access each
+// file of a directory tree, for example to audit it.
+//
+// The tree is walked with filepath.WalkDir, as the calling process, and each entry, including root
+// itself, is checked like Uid would, following symlinks. The permissions of the directories shared
+// by the entries are only read and checked once. ctx is checked between each entry, and during the
+// checks.
+//
+// - ctx controls the lifetime of the walk
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on each file, for example Read, Write, and/or Execute
+//
+// - root is the path of the directory to walk
+//
+// - returns a map from the path of each entry, as passed by filepath.WalkDir, to the error Uid would return for it (nil if the user has the requested access to it), or to the error that prevented the process from reading it
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if ctx is done
+func EvaluateTree(ctx context.Context, uid int, mode os.FileMode, root string) (map[string]error, error) {
+	return defaultChecker.EvaluateTree(ctx, uid, mode, root)
+}
+
+// EvaluateTree is like the package-level EvaluateTree, using the options of the Checker.
+func (c *Checker) EvaluateTree(ctx context.Context, uid int, mode os.FileMode, root string) (map[string]error, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.evaluateTree(ctx, id.Uid, id.Gids, mode, root)
+}
+
+func (c *Checker) evaluateTree(ctx context.Context, uid int, gids []int, mode os.FileMode, root string) (map[string]error, error) {
+	w := c.newWalk(ctx, uid, gids)
+	errs := make(map[string]error)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			// the entry, or the entries of the directory, could not be read by the process
+			errs[path] = err
+			return nil
+		}
+		dest, err := w.resolve(path, true)
+		if err == nil {
+			err = w.checkPath(mode, dest)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errs[path] = err
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// SameTarget checks whether two paths resolve to the same file, and whether a user identified by
+// its uid has the same access to the file through both paths, for example to deduplicate paths.
+//
+// Both paths are resolved like Uid would, and their resolved files are compared by their device
+// (st_dev) and inode (st_ino) numbers, so that hard links to a same file are the same target.
+// Like the ResolvedPath of a PermissionError, a path is resolved even through folders the user
+// cannot search, with the privileges of the calling process, so that the result can reveal
+// whether such paths lead to the same file: do not show it to the user unless they are allowed
+// to know it.
+// The access through a path can differ from the access through another path to the same file,
+// since the permissions of the ancestors along each path are checked.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - a and b are the paths to compare
+//
+// - returns true if a and b resolve to the same file, and the user either has the requested access to the file through both of them, or through none of them
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if a path cannot be resolved or its permissions cannot be read, in which case the error mentions the path
+func SameTarget(uid int, mode os.FileMode, a, b string) (bool, error) {
+	return defaultChecker.SameTarget(uid, mode, a, b)
+}
+
+// SameTarget is like the package-level SameTarget, using the options of the Checker.
+func (c *Checker) SameTarget(uid int, mode os.FileMode, a, b string) (bool, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return false, err
+	}
+	return c.sameTarget(id.Uid, id.Gids, mode, a, b)
+}
+
+func (c *Checker) sameTarget(uid int, gids []int, mode os.FileMode, a, b string) (bool, error) {
+	ta, err := c.target(uid, gids, mode, a)
+	if err != nil {
+		return false, fmt.Errorf("access: %s: %w", a, err)
+	}
+	tb, err := c.target(uid, gids, mode, b)
+	if err != nil {
+		return false, fmt.Errorf("access: %s: %w", b, err)
+	}
+	return ta == tb, nil
+}
+
+// target is the resolved file of a path, and the access of a user to it through the path
+type target struct {
+	dev     uint64
+	ino     uint64
+	allowed bool
+}
+
+func (c *Checker) target(uid int, gids []int, mode os.FileMode, path string) (target, error) {
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, true)
+	if err == nil {
+		err = w.checkPath(mode, dest)
+	}
+	var pe *PermissionError
+	if errors.As(err, &pe) {
+		// the resolution goes on after a denial, to report the resolved path
+		dest = pe.ResolvedPath
+	} else if err != nil {
+		return target{}, err
+	}
+	fi, err := w.lstat(dest)
+	if err != nil {
+		return target{}, err
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return target{}, err
+	}
+	return target{dev: st.dev, ino: st.ino, allowed: pe == nil}, nil
+}
+
+// grantedVia returns the permission class that grants mode on a file, assuming the access is granted
+func (w *walk) grantedVia(fm os.FileMode, mode os.FileMode, fileUid int, fileGid int) string {
+	need := w.override(fm, mode)
+	switch {
+	case need != mode:
+		return "root"
+	case classMode(fm, w.uid, w.gids, fileUid, fileGid)&need != need:
+		return "acl"
+	case w.uid == fileUid:
+		return "owner"
+	case contains(w.gids, fileGid):
+		return "group"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,123 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Explain returns a description of the error for end users, for example:
+//
+//	alice cannot search the directory /srv (to access /srv/data): it has mode drwx------ and is owned by user root and group root, so the permissions of other users (---) apply to alice, which lack search
+//
+// Unlike Error, uids and gids are looked up and replaced by their names, falling back to the
+// numeric ids if the lookup fails, and modes are rendered like ls does.
+func (p *PermissionError) Explain() string {
+	name := userName(p.Uid)
+
+	kind, verbs := "file", modeVerbs(p.WantMode, false)
+	if p.FileMode.IsDir() {
+		kind, verbs = "directory", modeVerbs(p.WantMode, true)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s cannot %s the %s %s", name, verbs, kind, p.File)
+	if p.ResolvedPath != "" && p.ResolvedPath != p.File {
+		fmt.Fprintf(&b, " (to access %s)", p.ResolvedPath)
+	}
+	fmt.Fprintf(&b, ": it has mode %v and is owned by user %s and group %s, so ", p.FileMode, userName(p.FileUid), groupName(p.FileGid))
+
+	class := classMode(p.FileMode, p.Uid, p.Gid, p.FileUid, p.FileGid)
+	switch {
+	case p.Uid == p.FileUid:
+		fmt.Fprintf(&b, "the permissions of its owner (%s) apply to %s", permString(class), name)
+	case contains(p.Gid, p.FileGid):
+		fmt.Fprintf(&b, "the permissions of its group (%s) apply to %s", permString(class), name)
+	default:
+		fmt.Fprintf(&b, "the permissions of other users (%s) apply to %s", permString(class), name)
+	}
+	fmt.Fprintf(&b, ", which lack %s", modeVerbs(p.MissingMode, p.FileMode.IsDir()))
+	return b.String()
+}
+
+// FileModeString returns the mode of the file like ls does, with its type and permission
+// bits, for example "drwxr-x---".
+//
+// The type is rendered as d (directory), l (symlink), p (named pipe), s (socket), c (character
+// device), b (block device) or - (regular file). The setuid, setgid and sticky bits are not
+// rendered.
+func (p *PermissionError) FileModeString() string {
+	fm := p.FileMode
+	t := byte('-')
+	switch {
+	case fm.IsDir():
+		t = 'd'
+	case fm&os.ModeSymlink != 0:
+		t = 'l'
+	case fm&os.ModeNamedPipe != 0:
+		t = 'p'
+	case fm&os.ModeSocket != 0:
+		t = 's'
+	case fm&os.ModeCharDevice != 0:
+		t = 'c'
+	case fm&os.ModeDevice != 0:
+		t = 'b'
+	}
+	return string(t) + permString(fm>>6) + permString(fm>>3) + permString(fm)
+}
+
+// WantModeString returns the requested permission like ls renders the permissions of a class,
+// for example "r-x" for Read|Execute.
+func (p *PermissionError) WantModeString() string {
+	return permString(p.WantMode)
+}
+
+// userName returns the name of a user, or its uid if it cannot be looked up
+func userName(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+	return "uid " + strconv.Itoa(uid)
+}
+
+// groupName returns the name of a group, or its gid if it cannot be looked up
+func groupName(gid int) string {
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return g.Name
+	}
+	return "gid " + strconv.Itoa(gid)
+}
+
+// modeVerbs returns the actions allowed by mode, for example "read and write"
+func modeVerbs(mode os.FileMode, dir bool) string {
+	var verbs []string
+	if mode&Read != 0 {
+		verbs = append(verbs, "read")
+	}
+	if mode&Write != 0 {
+		verbs = append(verbs, "write")
+	}
+	if mode&Execute != 0 {
+		if dir {
+			verbs = append(verbs, "search")
+		} else {
+			verbs = append(verbs, "execute")
+		}
+	}
+	if len(verbs) == 0 {
+		return "access"
+	}
+	return strings.Join(verbs, " and ")
+}
+
+// permString returns the rwx representation of the permissions of a class, in the low bits of perm
+func permString(perm os.FileMode) string {
+	b := []byte("---")
+	for i, c := range "rwx" {
+		if perm&(4>>uint(i)) != 0 {
+			b[i] = byte(c)
+		}
+	}
+	return string(b)
+}
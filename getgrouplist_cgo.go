@@ -0,0 +1,53 @@
+//go:build cgo && (linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build cgo
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package access
+
+/*
+#include <grp.h>
+#include <stdlib.h>
+#include <sys/types.h>
+#include <unistd.h>
+
+static int go_getgrouplist(const char *user, gid_t group, gid_t *groups, int *ngroups) {
+#ifdef __APPLE__
+	return getgrouplist(user, (int)group, (int *)groups, ngroups);
+#else
+	return getgrouplist(user, group, groups, ngroups);
+#endif
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// maximum number of groups read with getgrouplist
+const maxGrouplist = 65536
+
+// getgrouplist returns the gids of the groups of a user with getgrouplist(3), including group
+func getgrouplist(username string, group int) ([]int, error) {
+	name := C.CString(username)
+	defer C.free(unsafe.Pointer(name))
+	for n := 64; n <= maxGrouplist; {
+		groups := make([]C.gid_t, n)
+		ngroups := C.int(n)
+		if C.go_getgrouplist(name, C.gid_t(group), &groups[0], &ngroups) != -1 {
+			gids := make([]int, ngroups)
+			for i := range gids {
+				gids[i] = int(groups[i])
+			}
+			return gids, nil
+		}
+		// some implementations report the required number of groups, others do not
+		if int(ngroups) > n {
+			n = int(ngroups)
+		} else {
+			n *= 2
+		}
+	}
+	return nil, errors.New("access: too many groups")
+}
@@ -0,0 +1,9 @@
+//go:build !cgo || !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !cgo !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package access
+
+// getgrouplist(3) is only called with cgo, on Linux, macOS and BSDs
+func getgrouplist(username string, group int) ([]int, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,131 @@
+package access
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// file listing the groups and their members, read when the groups of a user cannot be looked up
+const groupFile = "/etc/group"
+
+// GroupLookupError is returned when the groups of a user cannot be looked up, as opposed
+// to the user itself, or the permissions of a file.
+type GroupLookupError struct {
+	// name of the user
+	Username string
+	// uid of the user
+	Uid string
+	// error returned by the group lookup
+	Err error
+}
+
+func (p *GroupLookupError) Error() string {
+	return fmt.Sprintf("access: looking up the groups of user %s (uid %s): %v", p.Username, p.Uid, p.Err)
+}
+
+func (p *GroupLookupError) Unwrap() error {
+	return p.Err
+}
+
+// parseId parses a uid or gid (kind) returned by the user database, tolerating the surrounding
+// whitespace and leading zeros returned by some NSS backends
+func parseId(kind string, s string) (int, error) {
+	id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("access: malformed %s %q from user database: %w", kind, s, err)
+	}
+	return int(id), nil
+}
+
+// groupIds returns the gids of a user, primary group first.
+//
+// If user.GroupIds fails, for example for system accounts missing from some NSS sources, the
+// groups are read from /etc/group instead: the primary gid of the user, followed by the gids of
+// the groups listing the user as a member.
+func groupIds(u *user.User) ([]int, error) {
+	gs, err := u.GroupIds()
+	if err != nil {
+		gi, ferr := groupIdsFromFile(u, groupFile)
+		if ferr != nil {
+			return nil, &GroupLookupError{Username: u.Username, Uid: u.Uid, Err: err}
+		}
+		return gi, nil
+	}
+	gi := make([]int, len(gs))
+	for i, g := range gs {
+		gi[i], err = parseId("gid", g)
+		if err != nil {
+			return nil, &GroupLookupError{Username: u.Username, Uid: u.Uid, Err: err}
+		}
+	}
+	return gi, nil
+}
+
+// groupIdsFromGrouplist returns the gids of a user with getgrouplist(3), primary group first
+func groupIdsFromGrouplist(u *user.User) ([]int, error) {
+	gid, err := parseId("gid", u.Gid)
+	if err != nil {
+		return nil, &GroupLookupError{Username: u.Username, Uid: u.Uid, Err: err}
+	}
+	gs, err := getgrouplist(u.Username, gid)
+	if err != nil {
+		return nil, &GroupLookupError{Username: u.Username, Uid: u.Uid, Err: err}
+	}
+	gi := []int{gid}
+	for _, g := range gs {
+		if g != gid {
+			gi = append(gi, g)
+		}
+	}
+	return gi, nil
+}
+
+func groupIdsFromFile(u *user.User, name string) ([]int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseGroupMembership(u, f)
+}
+
+// parseGroupMembership returns the primary gid of u, followed by the gids of the groups of a
+// group(5) file listing u as a member
+func parseGroupMembership(u *user.User, r io.Reader) ([]int, error) {
+	primary, err := parseId("gid", u.Gid)
+	if err != nil {
+		return nil, err
+	}
+	gi := []int{primary}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// name:password:gid:members
+		fields := strings.Split(line, ":")
+		if len(fields) != 4 {
+			continue
+		}
+		gid, err := parseId("gid", fields[2])
+		if err != nil || gid == primary {
+			continue
+		}
+		for _, m := range strings.Split(fields[3], ",") {
+			if m == u.Username {
+				gi = append(gi, gid)
+				break
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return gi, nil
+}
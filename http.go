@@ -0,0 +1,58 @@
+package access
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+)
+
+// GuardReadHandler returns a handler that serves a request with next only if the user making
+// it can read the requested file.
+//
+// The file is the path of the request URL, cleaned and joined to root, and is checked like
+// CheckWithin does, for the user uidFor returns for the request. If the user cannot read the
+// file, or the file escapes root, 403 Forbidden is returned; if the file does not exist, 404 Not
+// Found is returned; if uidFor or the check fail for another reason, 500 Internal Server Error is
+// returned. The check is done on the path before next opens it, for example with http.ServeFile
+// or http.FileServer serving root: the file can change between the check and its opening.
+//
+// - uidFor returns the *nix uid of the user making a request, for example after authenticating it
+//
+// - root is the absolute path of the directory files are served from, with all its symlinks already resolved
+//
+// - next is the handler serving the requests allowed to read their file
+func GuardReadHandler(uidFor func(*http.Request) (int, error), root string, next http.Handler) http.Handler {
+	return defaultChecker.GuardReadHandler(uidFor, root, next)
+}
+
+// GuardReadHandler is like the package-level GuardReadHandler, using the options of the Checker.
+func (c *Checker) GuardReadHandler(uidFor func(*http.Request) (int, error), root string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := uidFor(r)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if err := c.CheckWithin(root, uid, Read, name); err != nil {
+			httpError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// httpError replies to a request with the HTTP status matching a check error
+func httpError(w http.ResponseWriter, err error) {
+	var ne *NotDirError
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrPermission), errors.Is(err, ErrOutsideRoot):
+		code = http.StatusForbidden
+	case errors.Is(err, fs.ErrNotExist), errors.As(err, &ne):
+		code = http.StatusNotFound
+	}
+	http.Error(w, http.StatusText(code), code)
+}
@@ -0,0 +1,175 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// Identity is the identity of a user, as used for filesystem permission checks.
+type Identity struct {
+	// *nix uid of the user
+	Uid int
+	// *nix gids of the user: the first element is treated as the primary group, the others as the secondary groups
+	Gids []int
+}
+
+// IdentityForUid looks up the identity of a user identified by its uid.
+//
+// Looking up a user and its groups is expensive: the returned Identity can be cached and passed
+// to CheckIdentity to check many files for the same user.
+//
+// - uid is the *nix uid of the user
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if its groups cannot be looked up
+func IdentityForUid(uid int) (Identity, error) {
+	return defaultChecker.IdentityForUid(uid)
+}
+
+// IdentityForUid is like the package-level IdentityForUid, using the options of the Checker.
+func (c *Checker) IdentityForUid(uid int) (Identity, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return Identity{}, err
+	}
+	return c.identityOf(u)
+}
+
+// identityOf returns the identity of an already looked up user
+func (c *Checker) identityOf(u *user.User) (Identity, error) {
+	uid, err := parseId("uid", u.Uid)
+	if err != nil {
+		return Identity{}, err
+	}
+	var gi []int
+	if c.getgrouplist {
+		gi, err = groupIdsFromGrouplist(u)
+	} else {
+		gi, err = groupIds(u)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Uid: uid, Gids: gi}, nil
+}
+
+// CheckIdentity checks whether a user identified by its identity has the permissions to access a file.
+//
+// It is equivalent to Check(id.Uid, id.Gids, mode, path).
+//
+// - id is the identity of the user, for example as returned by IdentityForUid
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user has the requested access to the file
+func CheckIdentity(id Identity, mode os.FileMode, path string) error {
+	return defaultChecker.CheckIdentity(id, mode, path)
+}
+
+// CheckIdentity is like the package-level CheckIdentity, using the options of the Checker.
+func (c *Checker) CheckIdentity(id Identity, mode os.FileMode, path string) error {
+	return c.check(context.Background(), id.Uid, id.Gids, mode, path, true)
+}
+
+// CheckUser checks whether a process with a real and an effective identity, for example a
+// setuid program, has the permissions to access a file.
+//
+// As with the filesystem, the effective identity decides the permissions: it is the one checked
+// against the permission bits of the file and its ancestors, and the one reported in the
+// PermissionError. The real identity is only consulted for the sticky directory rules (see
+// CanDeleteUser), which do not apply to a mode check: for CheckUser, real is accepted so that
+// the same pair of identities can be passed to both functions.
+//
+// - real is the real identity of the process
+//
+// - effective is the effective identity of the process
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the effective identity does not have the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the process has the requested access to the file
+func CheckUser(real, effective Identity, mode os.FileMode, path string) error {
+	return defaultChecker.CheckUser(real, effective, mode, path)
+}
+
+// CheckUser is like the package-level CheckUser, using the options of the Checker.
+func (c *Checker) CheckUser(real, effective Identity, mode os.FileMode, path string) error {
+	return c.checkUser(real, effective, mode, path)
+}
+
+func (c *Checker) checkUser(real, effective Identity, mode os.FileMode, path string) error {
+	w := c.newWalk(context.Background(), effective.Uid, effective.Gids)
+	w.realUid = real.Uid
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	return w.checkPath(mode, dest)
+}
+
+// CanDeleteUser checks whether a process with a real and an effective identity, for example a
+// setuid program, has the permissions to delete (or rename) a file.
+//
+// It behaves like CanDelete, except that the write and search permissions on the directory
+// containing the file are checked for the effective identity, while the sticky directory rule
+// (owning either the file or the directory) is checked for the real uid. This differs from the
+// kernel, which checks the sticky directory rule for the filesystem uid of the process (usually
+// its effective uid): pass the effective identity as real to check it like the kernel does.
+//
+// - real is the real identity of the process
+//
+// - effective is the effective identity of the process
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the effective identity does not have access to the directory containing the file
+//
+// - returns a StickyError if the real uid does not own the file nor its sticky directory
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the process can delete the file
+func CanDeleteUser(real, effective Identity, path string) error {
+	return defaultChecker.CanDeleteUser(real, effective, path)
+}
+
+// CanDeleteUser is like the package-level CanDeleteUser, using the options of the Checker.
+func (c *Checker) CanDeleteUser(real, effective Identity, path string) error {
+	return c.canDeleteUser(real, effective, path)
+}
+
+func (c *Checker) canDeleteUser(real, effective Identity, path string) error {
+	path, err := absPath(path)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	if name == "" {
+		return errors.New("access: cannot delete root directory: " + path)
+	}
+
+	w := c.newWalk(context.Background(), effective.Uid, effective.Gids)
+	w.realUid = real.Uid
+	dir, err = w.resolveDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := w.checkPath(Write|Execute, dir); err != nil {
+		return err
+	}
+	return w.checkSticky(dir, filepath.Join(dir, name))
+}
@@ -0,0 +1,167 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// permissionErrorJSON is the JSON representation of a PermissionError
+type permissionErrorJSON struct {
+	File         string `json:"file"`
+	FileMode     string `json:"fileMode"`
+	FileUid      int    `json:"fileUid"`
+	FileGid      int    `json:"fileGid"`
+	Uid          int    `json:"uid"`
+	Gid          []int  `json:"gid"`
+	WantMode     string `json:"wantMode"`
+	MissingMode  string `json:"missingMode"`
+	ResolvedPath string `json:"resolvedPath,omitempty"`
+}
+
+// MarshalJSON encodes the error as a JSON object, for example:
+//
+//	{"file":"/srv","fileMode":"040750","fileUid":0,"fileGid":0,"uid":1000,"gid":[1000,27],"wantMode":"0001","missingMode":"0001","resolvedPath":"/srv/data"}
+//
+// The modes are encoded as octal strings. FileMode is encoded like st_mode (see stat(2)), with
+// its file type and its setuid, setgid and sticky bits, so that for example a directory with
+// mode 0750 is encoded as "040750". Gid is always encoded as an array.
+func (p *PermissionError) MarshalJSON() ([]byte, error) {
+	gid := p.Gid
+	if gid == nil {
+		gid = []int{}
+	}
+	return json.Marshal(permissionErrorJSON{
+		File:         p.File,
+		FileMode:     octal(unixMode(p.FileMode)),
+		FileUid:      p.FileUid,
+		FileGid:      p.FileGid,
+		Uid:          p.Uid,
+		Gid:          gid,
+		WantMode:     octal(uint32(p.WantMode)),
+		MissingMode:  octal(uint32(p.MissingMode)),
+		ResolvedPath: p.ResolvedPath,
+	})
+}
+
+// UnmarshalJSON decodes an error encoded by MarshalJSON.
+func (p *PermissionError) UnmarshalJSON(data []byte) error {
+	var j permissionErrorJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	fileMode, err := parseOctal("fileMode", j.FileMode)
+	if err != nil {
+		return err
+	}
+	wantMode, err := parseOctal("wantMode", j.WantMode)
+	if err != nil {
+		return err
+	}
+	missingMode, err := parseOctal("missingMode", j.MissingMode)
+	if err != nil {
+		return err
+	}
+	*p = PermissionError{
+		File:         j.File,
+		FileMode:     fileModeOf(fileMode),
+		FileUid:      j.FileUid,
+		FileGid:      j.FileGid,
+		Uid:          j.Uid,
+		Gid:          j.Gid,
+		WantMode:     os.FileMode(wantMode),
+		MissingMode:  os.FileMode(missingMode),
+		ResolvedPath: j.ResolvedPath,
+	}
+	return nil
+}
+
+// octal returns mode as an octal string with a leading 0, for example "0750"
+func octal(mode uint32) string {
+	return fmt.Sprintf("0%03o", mode)
+}
+
+// parseOctal parses a mode encoded by octal, name being the name of the field
+func parseOctal(name string, s string) (uint32, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("access: malformed %s %q: %w", name, s, err)
+	}
+	return uint32(mode), nil
+}
+
+// file type and special bits of st_mode, see inode(7)
+const (
+	modeTypeMask = 0170000
+	modeSocket   = 0140000
+	modeSymlink  = 0120000
+	modeRegular  = 0100000
+	modeBlock    = 0060000
+	modeDir      = 0040000
+	modeChar     = 0020000
+	modeFIFO     = 0010000
+	modeSetuid   = 04000
+	modeSetgid   = 02000
+	modeSticky   = 01000
+)
+
+// unixMode returns fm as an st_mode, with its file type, permission, setuid, setgid and sticky bits
+func unixMode(fm os.FileMode) uint32 {
+	m := uint32(fm.Perm())
+	switch {
+	case fm.IsDir():
+		m |= modeDir
+	case fm&os.ModeSymlink != 0:
+		m |= modeSymlink
+	case fm&os.ModeNamedPipe != 0:
+		m |= modeFIFO
+	case fm&os.ModeSocket != 0:
+		m |= modeSocket
+	case fm&os.ModeCharDevice != 0:
+		m |= modeChar
+	case fm&os.ModeDevice != 0:
+		m |= modeBlock
+	default:
+		m |= modeRegular
+	}
+	if fm&os.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if fm&os.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if fm&os.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+// fileModeOf returns the FileMode of an st_mode, see unixMode
+func fileModeOf(m uint32) os.FileMode {
+	fm := os.FileMode(m).Perm()
+	switch m & modeTypeMask {
+	case modeDir:
+		fm |= os.ModeDir
+	case modeSymlink:
+		fm |= os.ModeSymlink
+	case modeFIFO:
+		fm |= os.ModeNamedPipe
+	case modeSocket:
+		fm |= os.ModeSocket
+	case modeChar:
+		fm |= os.ModeDevice | os.ModeCharDevice
+	case modeBlock:
+		fm |= os.ModeDevice
+	}
+	if m&modeSetuid != 0 {
+		fm |= os.ModeSetuid
+	}
+	if m&modeSetgid != 0 {
+		fm |= os.ModeSetgid
+	}
+	if m&modeSticky != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm
+}
@@ -0,0 +1,234 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsafeOwnership is returned when a file is not owned by a user, or is writable
+// by other users, where it must be under the exclusive control of the user.
+var ErrUnsafeOwnership = errors.New("access: unsafe ownership")
+
+// ErrUnsafeAncestor is returned when an ancestor of a file is writable by all users without
+// being sticky, so that any user could replace the file.
+var ErrUnsafeAncestor = errors.New("access: unsafe ancestor")
+
+// OwnershipError is returned by CheckOwnership and CheckSafeDir when a file is owned by
+// another user, or is writable by its group or by other users.
+//
+// It wraps ErrUnsafeOwnership.
+type OwnershipError struct {
+	// path of the file/folder, resolved, whose ownership or mode is unsafe
+	Path string
+	// uid of the user that must own the file
+	Uid int
+	// uid of the owner of the file
+	FileUid int
+	// mode of the file
+	FileMode os.FileMode
+	// whether the file is rejected because it is writable by its group or other users, rather
+	// than because of its owner
+	Writable bool
+}
+
+func (p *OwnershipError) Error() string {
+	if p.Writable {
+		return fmt.Sprintf("%v: %s is writable by its group or other users (mode %v)", ErrUnsafeOwnership, p.Path, p.FileMode)
+	}
+	return fmt.Sprintf("%v: %s is owned by uid %d, not uid %d", ErrUnsafeOwnership, p.Path, p.FileUid, p.Uid)
+}
+
+func (p *OwnershipError) Unwrap() error {
+	return ErrUnsafeOwnership
+}
+
+// UnsafeAncestorError is returned by CheckSafeDir, and by the checks of a Checker rejecting
+// world-writable ancestors (see WithDenyWorldWritableAncestors), when an ancestor of a file is
+// writable by all users without being sticky.
+//
+// It wraps ErrUnsafeAncestor.
+type UnsafeAncestorError struct {
+	// path of the ancestor, resolved
+	Path string
+	// mode of the ancestor
+	FileMode os.FileMode
+}
+
+func (p *UnsafeAncestorError) Error() string {
+	return fmt.Sprintf("%v: %s is writable by all users and not sticky (mode %v)", ErrUnsafeAncestor, p.Path, p.FileMode)
+}
+
+func (p *UnsafeAncestorError) Unwrap() error {
+	return ErrUnsafeAncestor
+}
+
+// CheckOwnership checks whether a user identified by its uid can read a file, and whether the
+// file and its ancestors up to a directory are under the exclusive control of the user.
+//
+// This is the check done by programs such as ssh(1) before trusting a configuration file: the
+// file and each of its ancestors up to stopAt (inclusive) must be owned by the user, and must
+// not be writable by their group nor by other users, otherwise another user could replace the
+// file. The check is done on the resolved path of the file, after following symlinks; the
+// ownership of the symlinks themselves is not checked. Unlike ssh(1), files owned by root
+// are not accepted, unless uid is 0.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the file/folder
+//
+// - stopAt is the path of the last ancestor of the file to check, for example the home directory of the user
+//
+// - returns a PermissionError if the user does not have read access to the file
+//
+// - returns an OwnershipError if the file or one of its ancestors up to stopAt is owned by another user or is writable by its group or other users
+//
+// - returns an OutsideRootError if the resolved file is not within the resolved stopAt
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can read the file, and the file and its ancestors up to stopAt are owned by the user and only writable by it
+func CheckOwnership(uid int, path string, stopAt string) error {
+	return defaultChecker.CheckOwnership(uid, path, stopAt)
+}
+
+// CheckOwnership is like the package-level CheckOwnership, using the options of the Checker.
+func (c *Checker) CheckOwnership(uid int, path string, stopAt string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.checkOwnership(id.Uid, id.Gids, path, stopAt)
+}
+
+func (c *Checker) checkOwnership(uid int, gids []int, path string, stopAt string) error {
+	w := c.newWalk(context.Background(), uid, gids)
+	stop, err := w.resolveDir(stopAt)
+	if err != nil {
+		return err
+	}
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	if err := w.checkPath(Read, dest); err != nil {
+		return err
+	}
+	if !within(stop, dest) {
+		return &OutsideRootError{Path: dest, Root: stop}
+	}
+
+	for p := dest; ; p = filepath.Dir(p) {
+		fi, err := w.lstat(p)
+		if err != nil {
+			return err
+		}
+		st, err := statOf(fi)
+		if err != nil {
+			return err
+		}
+		if st.uid != uid || fi.Mode()&0022 != 0 {
+			return &OwnershipError{
+				Path:     p,
+				Uid:      uid,
+				FileUid:  st.uid,
+				FileMode: fi.Mode(),
+				Writable: st.uid == uid,
+			}
+		}
+		if p == stop {
+			return nil
+		}
+	}
+}
+
+// CheckSafeDir checks whether a directory is safe for a user to create private files in, for
+// example as a spool or temporary directory.
+//
+// The path is resolved like Uid does, except that its final component must not be a symlink,
+// so that it cannot lead somewhere unexpected. The directory must be owned by the user or by
+// root, and must not be writable by its group or other users unless it is sticky, like /tmp.
+// None of its ancestors may be writable by all users without being sticky, otherwise any user
+// could replace the directory.
+//
+// - uid is the *nix uid of the user
+//
+// - path is the path of the directory
+//
+// - returns a SymlinkError if the final component of path is a symlink
+//
+// - returns a NotDirError if path is not a directory
+//
+// - returns an OwnershipError if the directory is owned by another user than uid and root, or is writable by its group or other users without being sticky
+//
+// - returns an UnsafeAncestorError if an ancestor of the directory is writable by all users without being sticky
+//
+// - returns a PermissionError if the user cannot search the ancestors of the directory
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the directory is safe for the user
+func CheckSafeDir(uid int, path string) error {
+	return defaultChecker.CheckSafeDir(uid, path)
+}
+
+// CheckSafeDir is like the package-level CheckSafeDir, using the options of the Checker.
+func (c *Checker) CheckSafeDir(uid int, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.checkSafeDir(id.Uid, id.Gids, path)
+}
+
+func (c *Checker) checkSafeDir(uid int, gids []int, path string) error {
+	w := c.newWalk(context.Background(), uid, gids)
+	dest, err := w.resolve(path, false)
+	if err != nil {
+		return err
+	}
+	fi, err := w.lstat(dest)
+	if err != nil {
+		return err
+	}
+	fm := fi.Mode()
+	if fm&os.ModeSymlink != 0 {
+		return &SymlinkError{Path: dest}
+	}
+	if !fm.IsDir() {
+		return &NotDirError{Path: dest}
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return err
+	}
+	if st.uid != uid && st.uid != 0 {
+		return &OwnershipError{Path: dest, Uid: uid, FileUid: st.uid, FileMode: fm}
+	}
+	if fm&0022 != 0 && fm&os.ModeSticky == 0 {
+		return &OwnershipError{Path: dest, Uid: uid, FileUid: st.uid, FileMode: fm, Writable: true}
+	}
+
+	for p := dest; p != string(os.PathSeparator); {
+		p = filepath.Dir(p)
+		if err := w.checkAncestor(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAncestor returns an UnsafeAncestorError if dir, an ancestor of a file, is writable by all
+// users without being sticky
+func (w *walk) checkAncestor(dir string) error {
+	fi, err := w.lstat(dir)
+	if err != nil {
+		return err
+	}
+	if fm := fi.Mode(); fm&0002 != 0 && fm&os.ModeSticky == 0 {
+		return &UnsafeAncestorError{Path: dir, FileMode: fm}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RenameError is returned by CanRename when a user cannot rename a file, and tells whether
+// the source or the destination side of the rename was denied.
+//
+// It wraps the error of the failed check, for example a PermissionError or a StickyError.
+type RenameError struct {
+	// path of the file to rename
+	OldPath string
+	// path the file is renamed to
+	NewPath string
+	// whether the check failed on the destination side (the directory the file is moved to, or
+	// the file it replaces), rather than on the source side
+	Destination bool
+	// error of the failed check
+	Err error
+}
+
+func (p *RenameError) Error() string {
+	side := "source"
+	if p.Destination {
+		side = "destination"
+	}
+	return fmt.Sprintf("access: cannot rename %s to %s: %s: %v", p.OldPath, p.NewPath, side, p.Err)
+}
+
+func (p *RenameError) Unwrap() error {
+	return p.Err
+}
+
+// CanRename checks whether a user has the permissions to rename a file, like rename(2).
+//
+// Renaming a file requires write and execute permissions on both the directory containing the
+// file and the directory it is moved to. If the source directory has the sticky bit set, the
+// user must own either the file or the directory, as for CanDelete; if the destination already
+// exists and its directory has the sticky bit set, the same rule applies to the replaced file.
+// Moving a directory to another directory additionally requires write permission on the moved
+// directory itself, whose ".." entry is updated. If the final component of oldpath or newpath
+// is a symlink, the symlink itself is renamed or replaced rather than its target.
+//
+// Whether the rename would fail for other reasons, for example because the two paths are on
+// different filesystems or because a directory would replace a file, is not checked.
+//
+// - uid is the *nix uid of the user
+//
+// - oldpath is the path of the file/folder to rename
+//
+// - newpath is the path the file/folder is renamed to
+//
+// - returns a RenameError wrapping a PermissionError or a StickyError if the user cannot rename the file, telling whether the source or the destination side was denied
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the user can rename the file
+func CanRename(uid int, oldpath, newpath string) error {
+	return defaultChecker.CanRename(uid, oldpath, newpath)
+}
+
+// CanRename is like the package-level CanRename, using the options of the Checker.
+func (c *Checker) CanRename(uid int, oldpath, newpath string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.canRename(id.Uid, id.Gids, oldpath, newpath)
+}
+
+func (c *Checker) canRename(uid int, gids []int, oldpath, newpath string) error {
+	oldpath, err := absPath(oldpath)
+	if err != nil {
+		return err
+	}
+	newpath, err = absPath(newpath)
+	if err != nil {
+		return err
+	}
+	oldDir, oldName := filepath.Split(oldpath)
+	newDir, newName := filepath.Split(newpath)
+	if oldName == "" || newName == "" {
+		return errors.New("access: cannot rename root directory: " + oldpath + " to " + newpath)
+	}
+
+	w := c.newWalk(context.Background(), uid, gids)
+	w.realUid = uid
+	source := func(err error) error {
+		return &RenameError{OldPath: oldpath, NewPath: newpath, Err: err}
+	}
+	destination := func(err error) error {
+		return &RenameError{OldPath: oldpath, NewPath: newpath, Destination: true, Err: err}
+	}
+
+	oldDir, err = w.resolveDir(oldDir)
+	if err != nil {
+		return source(err)
+	}
+	if err := w.checkPath(Write|Execute, oldDir); err != nil {
+		return source(err)
+	}
+	oldFile := filepath.Join(oldDir, oldName)
+	if err := w.checkSticky(oldDir, oldFile); err != nil {
+		return source(err)
+	}
+
+	newDir, err = w.resolveDir(newDir)
+	if err != nil {
+		return destination(err)
+	}
+	fi, err := w.lstat(oldFile)
+	if err != nil {
+		return source(err)
+	}
+	if fi.IsDir() && oldDir != newDir {
+		if err := w.checkPath(Write, oldFile); err != nil {
+			return source(err)
+		}
+	}
+
+	if err := w.checkPath(Write|Execute, newDir); err != nil {
+		return destination(err)
+	}
+	newFile := filepath.Join(newDir, newName)
+	if _, err := w.lstat(newFile); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return destination(err)
+	}
+	if err := w.checkSticky(newDir, newFile); err != nil {
+		return destination(err)
+	}
+	return nil
+}
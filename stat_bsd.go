@@ -0,0 +1,44 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package access
+
+import (
+	"os"
+	"syscall"
+)
+
+// st_flags file flags, see chflags(2)
+const (
+	ufImmutable = 0x2
+	ufAppend    = 0x4
+	sfImmutable = 0x20000
+	sfAppend    = 0x40000
+)
+
+func statFromSys(s *syscall.Stat_t) sysStat {
+	return sysStat{
+		uid:   int(s.Uid),
+		gid:   int(s.Gid),
+		dev:   uint64(s.Dev),
+		ino:   uint64(s.Ino),
+		nlink: uint64(s.Nlink),
+		flags: uint32(s.Flags),
+	}
+}
+
+// attrs reads the attributes of a file from its st_flags
+func attrs(path string) (Attrs, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return Attrs{}, err
+	}
+	st, err := statOf(fi)
+	if err != nil {
+		return Attrs{}, err
+	}
+	return Attrs{
+		Immutable:  st.flags&(ufImmutable|sfImmutable) != 0,
+		AppendOnly: st.flags&(ufAppend|sfAppend) != 0,
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows && !plan9
+// +build !darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows,!plan9
+
+package access
+
+import (
+	"syscall"
+)
+
+func statFromSys(s *syscall.Stat_t) sysStat {
+	return sysStat{
+		uid:   int(s.Uid),
+		gid:   int(s.Gid),
+		dev:   uint64(s.Dev),
+		ino:   uint64(s.Ino),
+		nlink: uint64(s.Nlink),
+	}
+}
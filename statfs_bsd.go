@@ -0,0 +1,30 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package access
+
+import (
+	"os"
+	"syscall"
+)
+
+// MNT_RDONLY mount flag
+const mntRdonly = 0x1
+
+func statfs(path string) (FSInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return FSInfo{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	var typ []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		typ = append(typ, byte(c))
+	}
+	return FSInfo{
+		ReadOnly: st.Flags&mntRdonly != 0,
+		Type:     string(typ),
+	}, nil
+}
@@ -0,0 +1,55 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ST_RDONLY mount flag
+const stRdonly = 0x1
+
+// names of the filesystem types, by f_type magic number, see statfs(2)
+var fsTypes = map[uint32]string{
+	unix.BINFMTFS_MAGIC:        "binfmt_misc",
+	unix.BPF_FS_MAGIC:          "bpf",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.CGROUP_SUPER_MAGIC:    "cgroup",
+	unix.CGROUP2_SUPER_MAGIC:   "cgroup2",
+	0x62656570:                 "configfs", // CONFIGFS_MAGIC
+	unix.DEBUGFS_MAGIC:         "debugfs",
+	unix.DEVPTS_SUPER_MAGIC:    "devpts",
+	unix.EFIVARFS_MAGIC:        "efivarfs",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.HUGETLBFS_MAGIC:       "hugetlbfs",
+	0x19800202:                 "mqueue", // MQUEUE_MAGIC
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.NSFS_MAGIC:            "nsfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.PROC_SUPER_MAGIC:      "proc",
+	unix.PSTOREFS_MAGIC:        "pstore",
+	unix.SECURITYFS_MAGIC:      "securityfs",
+	unix.SELINUX_MAGIC:         "selinuxfs",
+	unix.SYSFS_MAGIC:           "sysfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.TRACEFS_MAGIC:         "tracefs",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+}
+
+func statfs(path string) (FSInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return FSInfo{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	typ, ok := fsTypes[uint32(st.Type)]
+	if !ok {
+		typ = fmt.Sprintf("%#x", uint32(st.Type))
+	}
+	return FSInfo{
+		ReadOnly: st.Flags&stRdonly != 0,
+		Type:     typ,
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package access
+
+// reading filesystem information is only supported on Linux, macOS and FreeBSD
+func statfs(path string) (FSInfo, error) {
+	return FSInfo{}, ErrUnsupported
+}
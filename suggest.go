@@ -0,0 +1,74 @@
+package access
+
+import (
+	"context"
+	"os"
+)
+
+// FixStep is a change of the mode of a file that would grant a user access, as returned by SuggestFix.
+type FixStep struct {
+	// path of the file/folder whose mode must be changed
+	Path string
+	// permission class whose bits must be changed: "owner", "group" or "other"; this is the class
+	// that applies to the user, so that the change grants access to as few other users as possible
+	Class string
+	// permission bits to add, in the position of Class (for example 0040 to add read to the group)
+	Add os.FileMode
+	// mode of the file after the change, for example to pass to os.Chmod
+	Mode os.FileMode
+}
+
+// SuggestFix computes the minimal changes of file modes that would grant a user the permissions to access a file.
+//
+// Like CheckAll, it does not stop at the first denied file: it returns one FixStep for each denied
+// file on the path, in the order of the resolution. Nothing is changed on the filesystem. Only the
+// permission bits are considered: if POSIX ACLs are honored, the returned changes may not be
+// sufficient for files whose ACL restricts the access.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns the changes to make, or an empty slice if the user already has the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func SuggestFix(uid int, mode os.FileMode, path string) ([]FixStep, error) {
+	return defaultChecker.SuggestFix(uid, mode, path)
+}
+
+// SuggestFix is like the package-level SuggestFix, using the options of the Checker.
+func (c *Checker) SuggestFix(uid int, mode os.FileMode, path string) ([]FixStep, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.suggestFix(id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) suggestFix(uid int, gids []int, mode os.FileMode, path string) ([]FixStep, error) {
+	w := c.newWalk(context.Background(), uid, gids)
+	w.all = true
+	if err := w.checkCollect(mode, path); err != nil {
+		return nil, err
+	}
+	steps := make([]FixStep, 0, len(w.denials))
+	for _, pe := range w.denials {
+		class, shift := "other", 0
+		switch {
+		case pe.Uid == pe.FileUid:
+			class, shift = "owner", 6
+		case contains(pe.Gid, pe.FileGid):
+			class, shift = "group", 3
+		}
+		add := pe.MissingMode << shift
+		steps = append(steps, FixStep{
+			Path:  pe.File,
+			Class: class,
+			Add:   add,
+			Mode:  pe.FileMode | add,
+		})
+	}
+	return steps, nil
+}
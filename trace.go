@@ -0,0 +1,132 @@
+package access
+
+import (
+	"context"
+	"os"
+)
+
+// Step is a single permission decision made when checking the access of a user to a file, as returned by Trace.
+type Step struct {
+	// path of the file/folder on which the decision was made
+	Path string
+	// permission that was required on the file, for example Execute (search) for the ancestor folders
+	RequiredMode os.FileMode
+	// mode of the file, including its permission bits
+	FileMode os.FileMode
+	// uid of the owner of the file
+	FileUid int
+	// gid of the group of the file
+	FileGid int
+	// whether the user has the required permission on the file
+	Granted bool
+	// number of symlinks followed by the resolution when the decision was made
+	LinksWalked int
+	// device (st_dev) and inode (st_ino) numbers of the file, to tell when the resolution crosses
+	// filesystems or visits the same file twice
+	Dev uint64
+	Ino uint64
+}
+
+// Trace checks whether a user identified by its uid has the permissions to access a file, and records
+// every permission decision made along the way.
+//
+// Unlike Uid, Trace does not stop at the first denial: it keeps resolving the path and checking
+// permissions, so that the returned steps contain the whole chain of decisions, in the order they
+// were made. Each folder is checked only once. The resolution goes on inside folders the user
+// cannot search, so the steps reveal files and symlink targets the user could not see: only
+// show them to someone allowed to see them, for example an administrator.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns the permission decisions made, including those made before an error occurred
+//
+// - returns the first PermissionError if the user does not have the requested access to the file
+//
+// - returns a non-nil error if an underlying error occurs when reading permissions
+func Trace(uid int, mode os.FileMode, path string) ([]Step, error) {
+	return defaultChecker.Trace(uid, mode, path)
+}
+
+// Trace is like the package-level Trace, using the options of the Checker.
+func (c *Checker) Trace(uid int, mode os.FileMode, path string) ([]Step, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.trace(id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) trace(uid int, gids []int, mode os.FileMode, path string) ([]Step, error) {
+	var steps []Step
+	w := c.newWalk(context.Background(), uid, gids)
+	w.all = true
+	w.onStep = func(s Step) error {
+		steps = append(steps, s)
+		return nil
+	}
+	if err := w.checkCollect(mode, path); err != nil {
+		return steps, err
+	}
+	if len(w.denials) > 0 {
+		return steps, w.denials[0]
+	}
+	return steps, nil
+}
+
+// Walk checks whether a user identified by its uid has the permissions to access a file, and calls
+// fn for every permission decision made along the way, as it is made.
+//
+// It behaves like Trace, except that the steps are passed to fn instead of being collected, so
+// that they can be processed progressively. If fn returns a non-nil error, the walk stops and
+// returns that error.
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - fn is called for each permission decision, in the order they are made
+//
+// - returns the error returned by fn, if any
+//
+// - returns the first PermissionError if the user does not have the requested access to the file
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+func Walk(uid int, mode os.FileMode, path string, fn func(Step) error) error {
+	return defaultChecker.Walk(uid, mode, path, fn)
+}
+
+// Walk is like the package-level Walk, using the options of the Checker.
+func (c *Checker) Walk(uid int, mode os.FileMode, path string, fn func(Step) error) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.walkSteps(id.Uid, id.Gids, mode, path, fn)
+}
+
+func (c *Checker) walkSteps(uid int, gids []int, mode os.FileMode, path string, fn func(Step) error) error {
+	w := c.newWalk(context.Background(), uid, gids)
+	w.all = true
+	// the error of fn must not be hidden by a previous denial, see checkCollect
+	var stopped error
+	w.onStep = func(s Step) error {
+		stopped = fn(s)
+		return stopped
+	}
+	if err := w.checkCollect(mode, path); err != nil {
+		return err
+	}
+	if stopped != nil {
+		return stopped
+	}
+	if len(w.denials) > 0 {
+		return w.denials[0]
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package access
+
+import (
+	"context"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// events of the watched files that can change the outcome of a check: changes of their
+// permissions, owners or ACLs, and changes of the entries of the watched directories
+const watchMask = unix.IN_ATTRIB | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_DONT_FOLLOW
+
+// Watch checks whether a user identified by its uid has the permissions to access a file, and
+// checks it again whenever the file or one of its ancestors changes.
+//
+// The files read by the check (the file, its ancestors, and the symlinks resolved along the
+// way) are watched with inotify(7), and the check is run again whenever their permissions,
+// owners or ACLs change, or whenever entries are added to or removed from the watched
+// directories. The set of watched files is updated after each check.
+//
+// The outcome of the first check is sent on the returned channel immediately, then the outcome
+// of each subsequent check: nil if the user has the requested access to the file, otherwise the
+// error Uid would return. If watching the files fails, the error is sent and the channel is closed.
+// The channel is closed when ctx is done.
+//
+// Watch is only supported on Linux; on other platforms, ErrUnsupported is returned.
+//
+// - ctx controls the lifetime of the watch
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a non-nil error if the user does not exist (in which case the returned error is a UnknownUserIdError), or if the watch cannot be created
+func Watch(ctx context.Context, uid int, mode os.FileMode, path string) (<-chan error, error) {
+	return defaultChecker.Watch(ctx, uid, mode, path)
+}
+
+// Watch is like the package-level Watch, using the options of the Checker.
+func (c *Checker) Watch(ctx context.Context, uid int, mode os.FileMode, path string) (<-chan error, error) {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.watch(ctx, id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) watch(ctx context.Context, uid int, gids []int, mode os.FileMode, path string) (<-chan error, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// the file is non-blocking, so that closing it interrupts a pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	ch := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		f.Close()
+	}()
+	go func() {
+		defer close(ch)
+		defer close(done)
+
+		watches := make(map[int]bool)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			paths, verdict := c.watchedCheck(ctx, uid, gids, mode, path)
+			if ctx.Err() != nil {
+				return
+			}
+
+			next := make(map[int]bool, len(paths))
+			var failed error
+			for _, p := range paths {
+				wd, err := unix.InotifyAddWatch(fd, p, watchMask)
+				if err == unix.ENOENT {
+					// removed since the check: the watch of its parent directory catches it
+					continue
+				} else if err != nil {
+					failed = &os.PathError{Op: "inotify_add_watch", Path: p, Err: err}
+					break
+				}
+				next[wd] = true
+			}
+			if failed != nil {
+				select {
+				case ch <- failed:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for wd := range watches {
+				if !next[wd] {
+					unix.InotifyRmWatch(fd, uint32(wd))
+				}
+			}
+			watches = next
+
+			select {
+			case ch <- verdict:
+			case <-ctx.Done():
+				return
+			}
+
+			// wait for an event other than the removal of a watch
+			for changed := false; !changed; {
+				n, err := f.Read(buf)
+				if err != nil {
+					return
+				}
+				for b := buf[:n]; len(b) >= unix.SizeofInotifyEvent; {
+					e := (*unix.InotifyEvent)(unsafe.Pointer(&b[0]))
+					if e.Mask&unix.IN_IGNORED == 0 {
+						changed = true
+					}
+					b = b[unix.SizeofInotifyEvent+int(e.Len):]
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// watchedCheck checks the access of the user, and returns the paths of the files read by the check
+func (c *Checker) watchedCheck(ctx context.Context, uid int, gids []int, mode os.FileMode, path string) ([]string, error) {
+	w := c.newWalk(ctx, uid, gids)
+	dest, err := w.resolve(path, true)
+	if err == nil {
+		err = w.checkPath(mode, dest)
+	}
+	paths := make([]string, 0, len(w.stats))
+	for p := range w.stats {
+		paths = append(paths, p)
+	}
+	return paths, err
+}
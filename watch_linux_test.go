@@ -0,0 +1,69 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := defaultChecker.watch(ctx, 0x7ffffffe, []int{0x7ffffffe}, Read, file)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	next := func() error {
+		select {
+		case err, ok := <-ch:
+			if !ok {
+				t.Fatal("channel closed")
+			}
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a check")
+		}
+		return nil
+	}
+
+	var pe *PermissionError
+	if err := next(); !errors.As(err, &pe) || pe.File != dir {
+		t.Fatalf("private directory: got %v, want PermissionError on the directory", err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(); !errors.As(err, &pe) || pe.File != file {
+		t.Fatalf("private file: got %v, want PermissionError on the file", err)
+	}
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(); err != nil {
+		t.Fatalf("readable file: got %v", err)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
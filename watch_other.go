@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package access
+
+import (
+	"context"
+	"os"
+)
+
+// Watch checks whether a user identified by its uid has the permissions to access a file, and
+// checks it again whenever the file or one of its ancestors changes.
+//
+// Watch is only supported on Linux; on other platforms, ErrUnsupported is returned.
+func Watch(ctx context.Context, uid int, mode os.FileMode, path string) (<-chan error, error) {
+	return nil, ErrUnsupported
+}
+
+// Watch is like the package-level Watch, using the options of the Checker.
+func (c *Checker) Watch(ctx context.Context, uid int, mode os.FileMode, path string) (<-chan error, error) {
+	return nil, ErrUnsupported
+}
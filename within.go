@@ -0,0 +1,110 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned when a path escapes the root it is confined to.
+var ErrOutsideRoot = errors.New("access: path escapes root")
+
+// OutsideRootError is returned by CheckWithin when a path escapes its root while
+// being resolved.
+//
+// It wraps ErrOutsideRoot.
+type OutsideRootError struct {
+	// path, partially resolved, that is outside the root
+	Path string
+	// root the path is confined to
+	Root string
+}
+
+func (p *OutsideRootError) Error() string {
+	return fmt.Sprintf("%v: %s is outside %s", ErrOutsideRoot, p.Path, p.Root)
+}
+
+func (p *OutsideRootError) Unwrap() error {
+	return ErrOutsideRoot
+}
+
+// CheckWithin checks whether a user identified by its uid has the permissions to access a file,
+// and that the file is confined to a root directory.
+//
+// It behaves like Uid, except that an OutsideRootError is returned if, at any point of the
+// resolution of path, the resolved path escapes root: for example with a ".." component, or
+// with a symlink to an absolute path or to a relative path outside root. The resolution may
+// walk through the ancestors of root before entering it, but not once it has entered it, except
+// to follow an absolute symlink whose target is within root.
+// Relative paths are relative to the current directory, not to root.
+//
+// - root is the absolute path of the root directory, with all its symlinks already resolved
+//
+// - uid is the *nix uid of the user
+//
+// - mode is the requested permission on the file, for example Read, Write, and/or Execute
+//
+// - path is the path of the file/folder
+//
+// - returns a PermissionError if the user does not have access the requested access to the file
+//
+// - returns an OutsideRootError if path escapes root
+//
+// - returns a non-nil error if root is not absolute, if the user does not exist (in which case the returned error is a UnknownUserIdError), or if an underlying error occurs when reading permissions
+//
+// - if the error is nil, the file is within root and the user has the requested access to it
+func CheckWithin(root string, uid int, mode os.FileMode, path string) error {
+	return defaultChecker.CheckWithin(root, uid, mode, path)
+}
+
+// CheckWithin is like the package-level CheckWithin, using the options of the Checker.
+func (c *Checker) CheckWithin(root string, uid int, mode os.FileMode, path string) error {
+	id, err := c.IdentityForUid(uid)
+	if err != nil {
+		return err
+	}
+	return c.checkWithin(root, id.Uid, id.Gids, mode, path)
+}
+
+func (c *Checker) checkWithin(root string, uid int, gids []int, mode os.FileMode, path string) error {
+	if !filepath.IsAbs(root) {
+		return errors.New("access: root is not an absolute path: " + root)
+	}
+	w := c.newWalk(context.Background(), uid, gids)
+	w.root = filepath.Clean(root)
+	dest, err := w.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	return w.checkPath(mode, dest)
+}
+
+// confine checks that dest, a partially resolved path, does not escape the root of the walk, if
+// any: dest must be within the root, or be an ancestor of the root if the root was not entered yet
+func (w *walk) confine(entered *bool, dest string) error {
+	if w.root == "" {
+		return nil
+	}
+	if within(w.root, dest) {
+		*entered = true
+		return nil
+	}
+	if !*entered && within(dest, w.root) {
+		return nil
+	}
+	return &OutsideRootError{Path: dest, Root: w.root}
+}
+
+// within returns whether path is dir or one of its descendants, both paths being clean
+func within(dir string, path string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(os.PathSeparator)) {
+		dir += string(os.PathSeparator)
+	}
+	return strings.HasPrefix(path, dir)
+}
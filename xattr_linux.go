@@ -0,0 +1,31 @@
+package access
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func getxattr(path string, attr string) ([]byte, error) {
+	for {
+		sz, err := syscall.Getxattr(path, attr, nil)
+		if err == nil && sz > 0 {
+			b := make([]byte, sz)
+			sz, err = syscall.Getxattr(path, attr, b)
+			if err == nil {
+				return b[:sz], nil
+			}
+		}
+		if errors.Is(err, syscall.ERANGE) {
+			// the attribute grew between the calls
+			continue
+		}
+		if errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+		}
+		return nil, nil
+	}
+}
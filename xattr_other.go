@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package access
+
+// extended attributes are only supported on Linux
+func getxattr(path string, attr string) ([]byte, error) {
+	return nil, nil
+}